@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix = "raymond-"
+	backupSuffix = ".sqlite"
+)
+
+// Backup writes a consistent snapshot of the database into BackupDir with
+// VACUUM INTO, which is safe to run while the server keeps writing. Only the
+// latest BackupKeep snapshots are kept.
+func (d *Deps) Backup(ctx context.Context) error {
+	if err := os.MkdirAll(d.BackupDir, 0o755); err != nil {
+		return fmt.Errorf("creating backup directory %s: %w", d.BackupDir, err)
+	}
+
+	// The timestamp sorts lexically, which is what pruning relies on.
+	path := filepath.Join(d.BackupDir, backupPrefix+d.now().UTC().Format("20060102T150405Z")+backupSuffix)
+	if _, err := d.DB.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("backing up to %s: %w", path, err)
+	}
+
+	log.Printf("Database backed up to %s", path)
+
+	entries, err := os.ReadDir(d.BackupDir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+
+	sort.Strings(backups)
+	for len(backups) > d.BackupKeep {
+		if err := os.Remove(filepath.Join(d.BackupDir, backups[0])); err != nil {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// RunBackups calls Backup on every interval until ctx is done.
+func (d *Deps) RunBackups(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			backupCtx, cancel := context.WithTimeout(ctx, time.Minute*10)
+			if err := d.Backup(backupCtx); err != nil {
+				log.Println(err)
+			}
+			cancel()
+		}
+	}
+}
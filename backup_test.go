@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.BackupDir = filepath.Join(t.TempDir(), "backups")
+	deps.BackupKeep = 2
+	silenceLog(t)
+
+	doAdd(t, deps)
+
+	start := time.Date(2022, time.July, 19, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		now := start.Add(time.Hour * time.Duration(i))
+		deps.Now = func() time.Time {
+			return now
+		}
+
+		if err := deps.Backup(context.Background()); err != nil {
+			t.Fatalf("backing up: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(deps.BackupDir)
+	if err != nil {
+		t.Fatalf("reading backup directory: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	want := []string{"raymond-20220719T110000Z.sqlite", "raymond-20220719T120000Z.sqlite"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Fatalf("expected backups %v, got %v", want, names)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(deps.BackupDir, want[1]))
+	if err != nil {
+		t.Fatalf("opening backup: %v", err)
+	}
+	defer db.Close()
+
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&rows); err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+
+	if rows != 1 {
+		t.Errorf("expected the backup to hold 1 event, got %d", rows)
+	}
+}
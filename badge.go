@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// badgeTemplate is a flat shields.io style badge. Widths are estimated from
+// the number of characters, which is close enough for Verdana at 11px.
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{html .Label}}: {{.Count}}">
+<title>{{html .Label}}: {{.Count}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.CountWidth}}" height="20" fill="#1d7484"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{html .Label}}</text>
+<text x="{{.CountX}}" y="14">{{.Count}}</text>
+</g>
+</svg>
+`))
+
+type badgeData struct {
+	Label      string
+	Count      int
+	LabelWidth int
+	CountWidth int
+	Width      int
+	LabelX     int
+	CountX     int
+}
+
+func newBadgeData(label string, count int) badgeData {
+	b := badgeData{
+		Label:      label,
+		Count:      count,
+		LabelWidth: badgeTextWidth(label),
+		CountWidth: badgeTextWidth(strconv.Itoa(count)),
+	}
+	b.Width = b.LabelWidth + b.CountWidth
+	b.LabelX = b.LabelWidth / 2
+	b.CountX = b.LabelWidth + b.CountWidth/2
+	return b
+}
+
+func badgeTextWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+// Badge renders the current count of the counter picked by ?name= as an SVG
+// badge labelled with the counter name, for embedding wherever images work.
+func (d *Deps) Badge(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	counts, _, err := d.latestAggregate(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var badge bytes.Buffer
+	if err := badgeTemplate.Execute(&badge, newBadgeData(name, counts)); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Image proxies such as GitHub's camo cache aggressively, a minute keeps
+	// the badge reasonably fresh without hammering the server.
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Content-Length", strconv.Itoa(badge.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(badge.Bytes())
+}
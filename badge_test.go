@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBadge(t *testing.T) {
+	deps := newTestDeps(t)
+
+	for i := 0; i < 42; i++ {
+		doAdd(t, deps)
+	}
+	createAggregate(t, deps)
+
+	rec := httptest.NewRecorder()
+	deps.Badge(rec, httptest.NewRequest(http.MethodGet, "/api/badge.svg", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "image/svg+xml" {
+		t.Errorf("expected an SVG content type, got %q", got)
+	}
+
+	if got := rec.Header().Get("Cache-Control"); got == "" {
+		t.Error("expected cache headers")
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{`<svg xmlns="http://www.w3.org/2000/svg"`, `aria-label="sorry: 42"`, `>sorry</text>`, `>42</text>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the badge to contain %q, got %s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Badge(rec, httptest.NewRequest(http.MethodGet, "/api/badge.svg?name=<script>", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid name, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type listCacheEntry struct {
+	counts    int
+	lastDate  time.Time
+	expiresAt time.Time
+}
+
+// listCache holds the last aggregate List read from the database for every
+// counter name. Its zero value is an empty cache ready to use.
+type listCache struct {
+	mu      sync.Mutex
+	entries map[string]listCacheEntry
+	// gen is bumped on every invalidation, so a read that raced with a write
+	// cannot store its now outdated result.
+	gen uint64
+}
+
+func (c *listCache) get(name string) (int, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[name]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return 0, time.Time{}, false
+	}
+
+	return entry.counts, entry.lastDate, true
+}
+
+func (c *listCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// set stores a value read while the cache was at the given generation. It is
+// dropped if the cache has been invalidated since.
+func (c *listCache) set(generation uint64, name string, counts int, lastDate time.Time, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.gen {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]listCacheEntry)
+	}
+
+	c.entries[name] = listCacheEntry{
+		counts:    counts,
+		lastDate:  lastDate,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+func (c *listCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = nil
+}
@@ -0,0 +1,765 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the server reads at startup. The json keys mirror
+// the environment variable names so a config file reads the same way as the
+// environment it replaces.
+type Config struct {
+	Port                string   `json:"PORT"`
+	Host                string   `json:"HOST"`
+	AdminAddr           string   `json:"ADMIN_ADDR"`
+	DatabaseURL         string   `json:"DATABASE_URL"`
+	ReadDatabaseURL     string   `json:"READ_DATABASE_URL"`
+	SkipMigration       bool     `json:"SKIP_MIGRATION"`
+	SQLiteForeignKeys   bool     `json:"SQLITE_FOREIGN_KEYS"`
+	SQLiteStrict        bool     `json:"SQLITE_STRICT"`
+	WebhookURL          string   `json:"WEBHOOK_URL"`
+	AggregateDebounceMS int      `json:"AGGREGATE_DEBOUNCE_MS"`
+	AggregateKeep       int      `json:"AGGREGATE_KEEP"`
+	AggregateMode       string   `json:"AGGREGATE_MODE"`
+	ReadYourWrites      bool     `json:"READ_YOUR_WRITES"`
+	TrustProxy          bool     `json:"TRUST_PROXY"`
+	TrustedProxies      string   `json:"TRUSTED_PROXIES"`
+	ForceHTTPS          bool     `json:"FORCE_HTTPS"`
+	MaxBodyBytes        int64    `json:"MAX_BODY_BYTES"`
+	ListCacheTTL        Duration `json:"LIST_CACHE_TTL"`
+	ReadyMaxLag         Duration `json:"READY_MAX_LAG"`
+	ReadyMaxDrift       int      `json:"READY_MAX_DRIFT"`
+	AddCooldown         Duration `json:"ADD_COOLDOWN"`
+	MilestoneEvery      int      `json:"MILESTONE_EVERY"`
+	Target              int      `json:"TARGET"`
+	SeedCount           int      `json:"SEED_COUNT"`
+	Subjects            string   `json:"SUBJECTS"`
+	ThemeMode           string   `json:"THEME_MODE"`
+	ThemeAccent         string   `json:"THEME_ACCENT"`
+	ShutdownTimeout     Duration `json:"SHUTDOWN_TIMEOUT"`
+	RequestTimeout      Duration `json:"REQUEST_TIMEOUT"`
+	TxMaxAttempts       int      `json:"TX_MAX_ATTEMPTS"`
+	DBIsolation         string   `json:"DB_ISOLATION"`
+	EnablePprof         bool     `json:"ENABLE_PPROF"`
+	ServerTimeHeader    bool     `json:"SERVER_TIME_HEADER"`
+	DefaultWeight       int      `json:"DEFAULT_WEIGHT"`
+	BackupDir           string   `json:"BACKUP_DIR"`
+	BackupInterval      Duration `json:"BACKUP_INTERVAL"`
+	BackupKeep          int      `json:"BACKUP_KEEP"`
+	Timezone            string   `json:"TIMEZONE"`
+	APIKey              string   `json:"API_KEY"`
+	CookieSecret        string   `json:"COOKIE_SECRET"`
+	JSONCase            string   `json:"JSON_CASE"`
+	Maintenance         bool     `json:"MAINTENANCE"`
+	MaxImportBytes      int64    `json:"MAX_IMPORT_BYTES"`
+	VacuumOnShutdown    bool     `json:"VACUUM_ON_SHUTDOWN"`
+	EnableWriteBuffer   bool     `json:"ENABLE_WRITE_BUFFER"`
+	TemplateFile        string   `json:"TEMPLATE_FILE"`
+	Env                 string   `json:"ENV"`
+	// ContentSecurityPolicy is sent with every response, embedders can loosen
+	// frame-ancestors here.
+	ContentSecurityPolicy string `json:"CONTENT_SECURITY_POLICY"`
+
+	// Resolved by Validate from TrustedProxies, Timezone, Subjects and
+	// DBIsolation.
+	trustedProxies []*net.IPNet
+	location       *time.Location
+	subjects       []string
+	isolation      sql.IsolationLevel
+}
+
+// Duration is a time.Duration written the way time.ParseDuration reads it,
+// e.g. "1s" or "250ms", both in the environment and in the config file.
+// Negative durations are rejected wherever they come from.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String and Set let a Duration be used as a flag.Value.
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// lookupDuration parses the environment variable named key into dst when it
+// is set, rejecting negative values.
+func lookupDuration(key string, dst *Duration) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(v)
+	if err != nil || duration < 0 {
+		return fmt.Errorf("invalid %s value: %q", key, v)
+	}
+
+	*dst = Duration(duration)
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:                  "80",
+		Host:                  "0.0.0.0",
+		DatabaseURL:           "./db.sqlite",
+		SQLiteForeignKeys:     true,
+		AggregateDebounceMS:   250,
+		AggregateKeep:         100,
+		AggregateMode:         AggregateAsync,
+		MaxBodyBytes:          64 << 10,
+		ListCacheTTL:          Duration(time.Second),
+		ReadyMaxLag:           Duration(time.Second * 30),
+		ReadyMaxDrift:         10,
+		ShutdownTimeout:       Duration(time.Second * 15),
+		RequestTimeout:        Duration(time.Second * 30),
+		TxMaxAttempts:         5,
+		DBIsolation:           "serializable",
+		DefaultWeight:         1,
+		BackupInterval:        Duration(time.Hour * 24),
+		BackupKeep:            7,
+		Timezone:              "UTC",
+		MaxImportBytes:        10 << 20,
+		ContentSecurityPolicy: defaultContentSecurityPolicy,
+		JSONCase:              "camel",
+		ThemeMode:             ThemeLight,
+		ServerTimeHeader:      true,
+	}
+}
+
+// LoadFile overrides the config with the values found in the JSON file at
+// path. Keys missing from the file keep their current value.
+func (c *Config) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// LoadEnv overrides the config with any of the environment variables that are
+// set.
+func (c *Config) LoadEnv() error {
+	if v, ok := os.LookupEnv("PORT"); ok {
+		c.Port = v
+	}
+
+	if v, ok := os.LookupEnv("HOST"); ok {
+		c.Host = v
+	}
+
+	if v, ok := os.LookupEnv("ADMIN_ADDR"); ok {
+		c.AdminAddr = v
+	}
+
+	if v, ok := os.LookupEnv("DATABASE_URL"); ok {
+		c.DatabaseURL = v
+	}
+
+	if v, ok := os.LookupEnv("READ_DATABASE_URL"); ok {
+		c.ReadDatabaseURL = v
+	}
+
+	if v, ok := os.LookupEnv("SKIP_MIGRATION"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SKIP_MIGRATION value: %q", v)
+		}
+
+		c.SkipMigration = b
+	}
+
+	if v, ok := os.LookupEnv("SQLITE_FOREIGN_KEYS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SQLITE_FOREIGN_KEYS value: %q", v)
+		}
+
+		c.SQLiteForeignKeys = b
+	}
+
+	if v, ok := os.LookupEnv("SQLITE_STRICT"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SQLITE_STRICT value: %q", v)
+		}
+
+		c.SQLiteStrict = b
+	}
+
+	if v, ok := os.LookupEnv("WEBHOOK_URL"); ok {
+		c.WebhookURL = v
+	}
+
+	if v, ok := os.LookupEnv("AGGREGATE_DEBOUNCE_MS"); ok {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return fmt.Errorf("invalid AGGREGATE_DEBOUNCE_MS value: %q", v)
+		}
+
+		c.AggregateDebounceMS = ms
+	}
+
+	if v, ok := os.LookupEnv("AGGREGATE_KEEP"); ok {
+		keep, err := strconv.Atoi(v)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("invalid AGGREGATE_KEEP value: %q", v)
+		}
+
+		c.AggregateKeep = keep
+	}
+
+	if v, ok := os.LookupEnv("AGGREGATE_MODE"); ok {
+		c.AggregateMode = v
+	}
+
+	if v, ok := os.LookupEnv("READ_YOUR_WRITES"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid READ_YOUR_WRITES value: %q", v)
+		}
+
+		c.ReadYourWrites = b
+	}
+
+	if v, ok := os.LookupEnv("TRUST_PROXY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid TRUST_PROXY value: %q", v)
+		}
+
+		c.TrustProxy = b
+	}
+
+	if v, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = v
+	}
+
+	if v, ok := os.LookupEnv("FORCE_HTTPS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid FORCE_HTTPS value: %q", v)
+		}
+
+		c.ForceHTTPS = b
+	}
+
+	if v, ok := os.LookupEnv("MAX_BODY_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid MAX_BODY_BYTES value: %q", v)
+		}
+
+		c.MaxBodyBytes = n
+	}
+
+	if err := lookupDuration("READY_MAX_LAG", &c.ReadyMaxLag); err != nil {
+		return err
+	}
+
+	if v, ok := os.LookupEnv("READY_MAX_DRIFT"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid READY_MAX_DRIFT value: %q", v)
+		}
+
+		c.ReadyMaxDrift = n
+	}
+
+	if err := lookupDuration("LIST_CACHE_TTL", &c.ListCacheTTL); err != nil {
+		return err
+	}
+
+	if err := lookupDuration("ADD_COOLDOWN", &c.AddCooldown); err != nil {
+		return err
+	}
+
+	if err := lookupDuration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout); err != nil {
+		return err
+	}
+
+	if err := lookupDuration("REQUEST_TIMEOUT", &c.RequestTimeout); err != nil {
+		return err
+	}
+
+	if v, ok := os.LookupEnv("BACKUP_DIR"); ok {
+		c.BackupDir = v
+	}
+
+	if err := lookupDuration("BACKUP_INTERVAL", &c.BackupInterval); err != nil {
+		return err
+	}
+
+	if v, ok := os.LookupEnv("BACKUP_KEEP"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid BACKUP_KEEP value: %q", v)
+		}
+
+		c.BackupKeep = n
+	}
+
+	if v, ok := os.LookupEnv("DEFAULT_WEIGHT"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minWeight || n > maxWeight {
+			return fmt.Errorf("invalid DEFAULT_WEIGHT value: %q", v)
+		}
+
+		c.DefaultWeight = n
+	}
+
+	if v, ok := os.LookupEnv("SERVER_TIME_HEADER"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SERVER_TIME_HEADER value: %q", v)
+		}
+
+		c.ServerTimeHeader = b
+	}
+
+	if v, ok := os.LookupEnv("ENABLE_PPROF"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid ENABLE_PPROF value: %q", v)
+		}
+
+		c.EnablePprof = b
+	}
+
+	if v, ok := os.LookupEnv("TX_MAX_ATTEMPTS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid TX_MAX_ATTEMPTS value: %q", v)
+		}
+
+		c.TxMaxAttempts = n
+	}
+
+	if v, ok := os.LookupEnv("DB_ISOLATION"); ok {
+		c.DBIsolation = v
+	}
+
+	if v, ok := os.LookupEnv("TIMEZONE"); ok {
+		c.Timezone = v
+	}
+
+	if v, ok := os.LookupEnv("API_KEY"); ok {
+		c.APIKey = v
+	}
+
+	if v, ok := os.LookupEnv("ENV"); ok {
+		c.Env = v
+	}
+
+	if v, ok := os.LookupEnv("TEMPLATE_FILE"); ok {
+		c.TemplateFile = v
+	}
+
+	if v, ok := os.LookupEnv("MAINTENANCE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid MAINTENANCE value: %q", v)
+		}
+
+		c.Maintenance = b
+	}
+
+	if v, ok := os.LookupEnv("JSON_CASE"); ok {
+		c.JSONCase = v
+	}
+
+	if v, ok := os.LookupEnv("COOKIE_SECRET"); ok {
+		c.CookieSecret = v
+	}
+
+	if v, ok := os.LookupEnv("CONTENT_SECURITY_POLICY"); ok {
+		c.ContentSecurityPolicy = v
+	}
+
+	if v, ok := os.LookupEnv("VACUUM_ON_SHUTDOWN"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid VACUUM_ON_SHUTDOWN value: %q", v)
+		}
+
+		c.VacuumOnShutdown = b
+	}
+
+	if v, ok := os.LookupEnv("ENABLE_WRITE_BUFFER"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid ENABLE_WRITE_BUFFER value: %q", v)
+		}
+
+		c.EnableWriteBuffer = b
+	}
+
+	if v, ok := os.LookupEnv("MILESTONE_EVERY"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid MILESTONE_EVERY value: %q", v)
+		}
+
+		c.MilestoneEvery = n
+	}
+
+	if v, ok := os.LookupEnv("SUBJECTS"); ok {
+		c.Subjects = v
+	}
+
+	if v, ok := os.LookupEnv("THEME_MODE"); ok {
+		c.ThemeMode = v
+	}
+
+	if v, ok := os.LookupEnv("THEME_ACCENT"); ok {
+		c.ThemeAccent = v
+	}
+
+	if v, ok := os.LookupEnv("TARGET"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid TARGET value: %q", v)
+		}
+
+		c.Target = n
+	}
+
+	if v, ok := os.LookupEnv("SEED_COUNT"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid SEED_COUNT value: %q", v)
+		}
+
+		c.SeedCount = n
+	}
+
+	if v, ok := os.LookupEnv("MAX_IMPORT_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid MAX_IMPORT_BYTES value: %q", v)
+		}
+
+		c.MaxImportBytes = n
+	}
+
+	return nil
+}
+
+// parseTrustedProxies parses a comma separated list of CIDRs. A bare IP
+// address is treated as a network containing only that address.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry: %q", entry)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry: %q", entry)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// parseSubjects splits a comma separated list of counter names, dropping
+// duplicates. Names are case-insensitive like everywhere else.
+func parseSubjects(s string) ([]string, error) {
+	var subjects []string
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" || seen[entry] {
+			continue
+		}
+
+		if !counterNamePattern.MatchString(entry) {
+			return nil, fmt.Errorf("invalid SUBJECTS entry: %q", entry)
+		}
+
+		seen[entry] = true
+		subjects = append(subjects, entry)
+	}
+
+	return subjects, nil
+}
+
+// LoadConfig gathers the config from its defaults, the JSON file named by
+// CONFIG_FILE, the environment and the command line flags in args, in that
+// order of precedence, and validates the result.
+func LoadConfig(args []string) (Config, error) {
+	config := defaultConfig()
+
+	if path, ok := os.LookupEnv("CONFIG_FILE"); ok {
+		if err := config.LoadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := config.LoadEnv(); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.ParseFlags(args); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// ParseFlags overrides the config with command line flags. Every flag
+// defaults to the value loaded so far, so flags win over environment variables
+// which win over the config file.
+func (c *Config) ParseFlags(args []string) error {
+	flags := flag.NewFlagSet("raymond", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: raymond [serve|migrate] [flags]\n\nEvery flag can also be set with the environment variable in brackets.\n\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&c.Port, "port", c.Port, "port to listen on [PORT]")
+	flags.StringVar(&c.Host, "host", c.Host, "host to listen on [HOST]")
+	flags.StringVar(&c.AdminAddr, "admin-addr", c.AdminAddr, "separate host:port for /metrics, pprof and recompute, served with the rest when empty [ADMIN_ADDR]")
+	flags.StringVar(&c.DatabaseURL, "db", c.DatabaseURL, "SQLite database path [DATABASE_URL]")
+	flags.StringVar(&c.ReadDatabaseURL, "read-db", c.ReadDatabaseURL, "replica of -db that lists and statistics are read from, -db itself when empty [READ_DATABASE_URL]")
+	flags.BoolVar(&c.SkipMigration, "skip-migration", c.SkipMigration, "verify the schema instead of migrating [SKIP_MIGRATION]")
+	flags.BoolVar(&c.SQLiteForeignKeys, "sqlite-foreign-keys", c.SQLiteForeignKeys, "enforce foreign keys on every database connection [SQLITE_FOREIGN_KEYS]")
+	flags.BoolVar(&c.SQLiteStrict, "sqlite-strict", c.SQLiteStrict, "create new tables as STRICT where the schema and SQLite allow it [SQLITE_STRICT]")
+	flags.StringVar(&c.WebhookURL, "webhook-url", c.WebhookURL, "URL notified after every increment [WEBHOOK_URL]")
+	flags.IntVar(&c.AggregateDebounceMS, "aggregate-debounce-ms", c.AggregateDebounceMS, "milliseconds to coalesce increments before aggregating [AGGREGATE_DEBOUNCE_MS]")
+	flags.IntVar(&c.AggregateKeep, "aggregate-keep", c.AggregateKeep, "number of aggregate rows kept when pruning [AGGREGATE_KEEP]")
+	flags.StringVar(&c.AggregateMode, "aggregate-mode", c.AggregateMode, "when writes update the aggregate: async, sync, or none to sum the counter on every read [AGGREGATE_MODE]")
+	flags.BoolVar(&c.ReadYourWrites, "read-your-writes", c.ReadYourWrites, "with -aggregate-mode async, answer writes once the aggregate includes them [READ_YOUR_WRITES]")
+	flags.BoolVar(&c.TrustProxy, "trust-proxy", c.TrustProxy, "read the client IP from X-Forwarded-For [TRUST_PROXY]")
+	flags.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "comma separated CIDRs of trusted proxies [TRUSTED_PROXIES]")
+	flags.BoolVar(&c.ForceHTTPS, "force-https", c.ForceHTTPS, "redirect pages requested over plain HTTP to https, needs -trust-proxy [FORCE_HTTPS]")
+	flags.Int64Var(&c.MaxBodyBytes, "max-body-bytes", c.MaxBodyBytes, "maximum request body size in bytes [MAX_BODY_BYTES]")
+	flags.Var(&c.ListCacheTTL, "list-cache-ttl", "how long /api/list may be served from memory [LIST_CACHE_TTL]")
+	flags.Var(&c.ReadyMaxLag, "ready-max-lag", "how long events may wait for the aggregate before /readyz reports degraded [READY_MAX_LAG]")
+	flags.IntVar(&c.ReadyMaxDrift, "ready-max-drift", c.ReadyMaxDrift, "how far the aggregate may be off before /readyz reports degraded [READY_MAX_DRIFT]")
+	flags.IntVar(&c.MilestoneEvery, "milestone-every", c.MilestoneEvery, "record a milestone whenever a total crosses a multiple of this, zero disables it [MILESTONE_EVERY]")
+	flags.StringVar(&c.Subjects, "subjects", c.Subjects, "comma separated counter names the page can switch between [SUBJECTS]")
+	flags.IntVar(&c.Target, "target", c.Target, "goal the count is tracked against, zero disables it [TARGET]")
+	flags.IntVar(&c.SeedCount, "seed-count", c.SeedCount, "count an empty database starts at after the migration, zero disables it [SEED_COUNT]")
+	flags.StringVar(&c.ThemeMode, "theme-mode", c.ThemeMode, "colour scheme of the page, light or dark [THEME_MODE]")
+	flags.StringVar(&c.ThemeAccent, "theme-accent", c.ThemeAccent, "hex colour replacing the accent of the page, e.g. #1d7484 [THEME_ACCENT]")
+	flags.Var(&c.AddCooldown, "add-cooldown", "minimum time between two increments of a counter, zero disables it [ADD_COOLDOWN]")
+	flags.StringVar(&c.BackupDir, "backup-dir", c.BackupDir, "directory for periodic database backups, disabled when empty [BACKUP_DIR]")
+	flags.Var(&c.BackupInterval, "backup-interval", "time between database backups [BACKUP_INTERVAL]")
+	flags.IntVar(&c.BackupKeep, "backup-keep", c.BackupKeep, "number of database backups kept [BACKUP_KEEP]")
+	flags.IntVar(&c.DefaultWeight, "default-weight", c.DefaultWeight, "weight of an increment that does not specify one, from 1 to 5 [DEFAULT_WEIGHT]")
+	flags.BoolVar(&c.EnablePprof, "enable-pprof", c.EnablePprof, "serve runtime profiles under /debug/pprof/ [ENABLE_PPROF]")
+	flags.BoolVar(&c.ServerTimeHeader, "server-time-header", c.ServerTimeHeader, "send the server time as X-Server-Time with API responses [SERVER_TIME_HEADER]")
+	flags.IntVar(&c.TxMaxAttempts, "tx-max-attempts", c.TxMaxAttempts, "attempts at a transaction that hit a lock or serialization failure [TX_MAX_ATTEMPTS]")
+	flags.StringVar(&c.DBIsolation, "db-isolation", c.DBIsolation, "isolation level of transactions, serializable or read-committed [DB_ISOLATION]")
+	flags.Var(&c.RequestTimeout, "request-timeout", "time after which a request is answered with 503, zero disables it [REQUEST_TIMEOUT]")
+	flags.Var(&c.ShutdownTimeout, "shutdown-timeout", "how long to wait for in-flight requests on shutdown [SHUTDOWN_TIMEOUT]")
+	flags.StringVar(&c.Timezone, "timezone", c.Timezone, "IANA timezone used to bucket statistics [TIMEZONE]")
+	flags.BoolVar(&c.Maintenance, "maintenance", c.Maintenance, "start read-only, writes get 503 until /api/maintenance turns it off [MAINTENANCE]")
+	flags.StringVar(&c.JSONCase, "json-case", c.JSONCase, "naming of JSON response keys, camel or snake [JSON_CASE]")
+	flags.StringVar(&c.CookieSecret, "cookie-secret", c.CookieSecret, "secret signing the visitor cookie, visitors are not tracked when empty [COOKIE_SECRET]")
+	flags.StringVar(&c.APIKey, "api-key", c.APIKey, "key required by the admin endpoints, they are disabled when empty [API_KEY]")
+	flags.Int64Var(&c.MaxImportBytes, "max-import-bytes", c.MaxImportBytes, "maximum size of a CSV import in bytes [MAX_IMPORT_BYTES]")
+	flags.StringVar(&c.ContentSecurityPolicy, "content-security-policy", c.ContentSecurityPolicy, "Content-Security-Policy sent with every response, loosen frame-ancestors to embed the HTML of /api/list [CONTENT_SECURITY_POLICY]")
+	flags.BoolVar(&c.VacuumOnShutdown, "vacuum-on-shutdown", c.VacuumOnShutdown, "checkpoint and vacuum the database on graceful shutdown [VACUUM_ON_SHUTDOWN]")
+	flags.StringVar(&c.Env, "env", c.Env, "deployment environment, development sends panic stack traces to clients [ENV]")
+	flags.StringVar(&c.TemplateFile, "template-file", c.TemplateFile, "HTML template served as the page instead of the built-in one [TEMPLATE_FILE]")
+	flags.BoolVar(&c.EnableWriteBuffer, "enable-write-buffer", c.EnableWriteBuffer, "queue increments in memory while the database is unavailable, at the cost of durability [ENABLE_WRITE_BUFFER]")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	return c.Validate()
+}
+
+// Validate checks the settings that can be out of range whichever way they
+// were given, and resolves the trusted proxies, the timezone, the subjects and
+// the isolation level for main. Errors name a setting by its environment
+// variable and its flag, as the value may come from either or CONFIG_FILE.
+func (c *Config) Validate() error {
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid PORT/-port value: %q", c.Port)
+	}
+
+	if c.AdminAddr != "" {
+		if _, port, err := net.SplitHostPort(c.AdminAddr); err != nil || port == "" {
+			return fmt.Errorf("invalid ADMIN_ADDR/-admin-addr value: %q", c.AdminAddr)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	c.trustedProxies = trustedProxies
+
+	subjects, err := parseSubjects(c.Subjects)
+	if err != nil {
+		return err
+	}
+	c.subjects = subjects
+
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid TIMEZONE/-timezone value: %q", c.Timezone)
+	}
+	c.location = location
+
+	if c.AggregateDebounceMS < 0 {
+		return fmt.Errorf("invalid AGGREGATE_DEBOUNCE_MS/-aggregate-debounce-ms value: %d", c.AggregateDebounceMS)
+	}
+
+	if c.AggregateKeep < 1 {
+		return fmt.Errorf("invalid AGGREGATE_KEEP/-aggregate-keep value: %d", c.AggregateKeep)
+	}
+
+	switch c.AggregateMode {
+	case AggregateAsync, AggregateSync, AggregateNone:
+	default:
+		return fmt.Errorf("invalid AGGREGATE_MODE/-aggregate-mode value: %q", c.AggregateMode)
+	}
+
+	if c.MaxBodyBytes < 1 {
+		return fmt.Errorf("invalid MAX_BODY_BYTES/-max-body-bytes value: %d", c.MaxBodyBytes)
+	}
+
+	if c.BackupInterval <= 0 {
+		return fmt.Errorf("invalid BACKUP_INTERVAL/-backup-interval value: %s", c.BackupInterval.String())
+	}
+
+	if c.BackupKeep < 1 {
+		return fmt.Errorf("invalid BACKUP_KEEP/-backup-keep value: %d", c.BackupKeep)
+	}
+
+	if c.DefaultWeight < minWeight || c.DefaultWeight > maxWeight {
+		return fmt.Errorf("invalid DEFAULT_WEIGHT/-default-weight value: %d", c.DefaultWeight)
+	}
+
+	if c.TxMaxAttempts < 1 {
+		return fmt.Errorf("invalid TX_MAX_ATTEMPTS/-tx-max-attempts value: %d", c.TxMaxAttempts)
+	}
+
+	switch c.DBIsolation {
+	case "serializable":
+		c.isolation = sql.LevelSerializable
+	case "read-committed":
+		c.isolation = sql.LevelReadCommitted
+	default:
+		return fmt.Errorf("invalid DB_ISOLATION/-db-isolation value: %q", c.DBIsolation)
+	}
+
+	if c.MaxImportBytes < 1 {
+		return fmt.Errorf("invalid MAX_IMPORT_BYTES/-max-import-bytes value: %d", c.MaxImportBytes)
+	}
+
+	if c.JSONCase != "camel" && c.JSONCase != "snake" {
+		return fmt.Errorf("invalid JSON_CASE/-json-case value: %q", c.JSONCase)
+	}
+
+	if c.MilestoneEvery < 0 {
+		return fmt.Errorf("invalid MILESTONE_EVERY/-milestone-every value: %d", c.MilestoneEvery)
+	}
+
+	if c.ReadyMaxDrift < 0 {
+		return fmt.Errorf("invalid READY_MAX_DRIFT/-ready-max-drift value: %d", c.ReadyMaxDrift)
+	}
+
+	if c.Target < 0 {
+		return fmt.Errorf("invalid TARGET/-target value: %d", c.Target)
+	}
+
+	if c.SeedCount < 0 {
+		return fmt.Errorf("invalid SEED_COUNT/-seed-count value: %d", c.SeedCount)
+	}
+
+	if c.ThemeMode != ThemeLight && c.ThemeMode != ThemeDark {
+		return fmt.Errorf("invalid THEME_MODE/-theme-mode value: %q", c.ThemeMode)
+	}
+
+	// The accent ends up in a stylesheet, nothing but a colour gets through.
+	if c.ThemeAccent != "" && !themeAccentPattern.MatchString(c.ThemeAccent) {
+		return fmt.Errorf("invalid THEME_ACCENT/-theme-accent value: %q", c.ThemeAccent)
+	}
+
+	// Without a trusted proxy there is no telling which scheme the client
+	// used, and the server itself only speaks HTTP.
+	if c.ForceHTTPS && !c.TrustProxy {
+		return fmt.Errorf("invalid FORCE_HTTPS/-force-https value: it requires TRUST_PROXY/-trust-proxy")
+	}
+
+	return nil
+}
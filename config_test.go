@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigEnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"PORT":"8080","HOST":"127.0.0.1","AGGREGATE_KEEP":10,"LIST_CACHE_TTL":"5s"}`), 0o644)
+	if err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+
+	t.Setenv("PORT", "9090")
+
+	config := defaultConfig()
+	if err := config.LoadFile(path); err != nil {
+		t.Fatalf("loading config file: %v", err)
+	}
+
+	if err := config.LoadEnv(); err != nil {
+		t.Fatalf("loading env: %v", err)
+	}
+
+	if config.Port != "9090" {
+		t.Errorf("expected PORT from env to win, got %q", config.Port)
+	}
+
+	if config.Host != "127.0.0.1" {
+		t.Errorf("expected HOST from file, got %q", config.Host)
+	}
+
+	if config.AggregateKeep != 10 {
+		t.Errorf("expected AGGREGATE_KEEP from file, got %d", config.AggregateKeep)
+	}
+
+	if time.Duration(config.ListCacheTTL) != time.Second*5 {
+		t.Errorf("expected LIST_CACHE_TTL from file, got %s", time.Duration(config.ListCacheTTL))
+	}
+
+	if config.DatabaseURL != "./db.sqlite" {
+		t.Errorf("expected default DATABASE_URL, got %q", config.DatabaseURL)
+	}
+}
+
+func TestConfigRejectsUnknownFileKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"PROT":"8080"}`), 0o644); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+
+	config := defaultConfig()
+	if err := config.LoadFile(path); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestConfigRejectsNegativeFileDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"REQUEST_TIMEOUT":"-5s"}`), 0o644); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+
+	config := defaultConfig()
+	if err := config.LoadFile(path); err == nil {
+		t.Error("expected an error for a negative duration")
+	}
+}
+
+func TestConfigFlagsOverrideEnv(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("DATABASE_URL", "./env.sqlite")
+
+	config := defaultConfig()
+	if err := config.LoadEnv(); err != nil {
+		t.Fatalf("loading env: %v", err)
+	}
+
+	if err := config.ParseFlags([]string{"-port", "8080", "-list-cache-ttl", "250ms"}); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+
+	if config.Port != "8080" {
+		t.Errorf("expected -port to win, got %q", config.Port)
+	}
+
+	if config.DatabaseURL != "./env.sqlite" {
+		t.Errorf("expected DATABASE_URL from env to be kept, got %q", config.DatabaseURL)
+	}
+
+	if time.Duration(config.ListCacheTTL) != time.Millisecond*250 {
+		t.Errorf("expected -list-cache-ttl to be parsed, got %s", time.Duration(config.ListCacheTTL))
+	}
+
+	if err := config.ParseFlags([]string{"-aggregate-keep", "0"}); err == nil {
+		t.Error("expected an error for an invalid -aggregate-keep")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	os.Unsetenv("CONFIG_FILE")
+	t.Setenv("TIMEZONE", "Asia/Jakarta")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+
+	config, err := LoadConfig([]string{"-port", "8080"})
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+
+	if config.Port != "8080" {
+		t.Errorf("expected -port to be applied, got %q", config.Port)
+	}
+
+	if config.location == nil || config.location.String() != "Asia/Jakarta" {
+		t.Errorf("expected the timezone to be resolved, got %v", config.location)
+	}
+
+	if len(config.trustedProxies) != 1 {
+		t.Errorf("expected the trusted proxies to be resolved, got %v", config.trustedProxies)
+	}
+
+	tests := []struct {
+		name string
+		env  string
+		val  string
+		want string
+	}{
+		{name: "non-numeric port", env: "PORT", val: "eighty", want: `invalid PORT/-port value: "eighty"`},
+		{name: "port out of range", env: "PORT", val: "70000", want: `invalid PORT/-port value: "70000"`},
+		{name: "unknown timezone", env: "TIMEZONE", val: "Mars/Olympus", want: `invalid TIMEZONE/-timezone value: "Mars/Olympus"`},
+		{name: "malformed proxies", env: "TRUSTED_PROXIES", val: "nope", want: `invalid TRUSTED_PROXIES entry: "nope"`},
+		{name: "malformed duration", env: "REQUEST_TIMEOUT", val: "soon", want: `invalid REQUEST_TIMEOUT value: "soon"`},
+		{name: "admin address without port", env: "ADMIN_ADDR", val: "localhost", want: `invalid ADMIN_ADDR/-admin-addr value: "localhost"`},
+		{name: "malformed subjects", env: "SUBJECTS", val: "sorry,thank you", want: `invalid SUBJECTS entry: "thank you"`},
+		{name: "unknown aggregate mode", env: "AGGREGATE_MODE", val: "lazy", want: `invalid AGGREGATE_MODE/-aggregate-mode value: "lazy"`},
+		{name: "unknown isolation level", env: "DB_ISOLATION", val: "snapshot", want: `invalid DB_ISOLATION/-db-isolation value: "snapshot"`},
+		{name: "negative seed count", env: "SEED_COUNT", val: "-5", want: `invalid SEED_COUNT value: "-5"`},
+		{name: "unknown theme mode", env: "THEME_MODE", val: "sepia", want: `invalid THEME_MODE/-theme-mode value: "sepia"`},
+		{name: "accent that is not a hex colour", env: "THEME_ACCENT", val: "red;}", want: `invalid THEME_ACCENT/-theme-accent value: "red;}"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.env, tt.val)
+
+			_, err := LoadConfig(nil)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
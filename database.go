@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sqliteFilePath returns the file a SQLite DSN points at. The second return
+// value is false for DSNs that are not backed by a file on disk, such as
+// in-memory databases or URLs meant for other drivers. Query parameters like
+// ?_busy_timeout=5000 are options for the driver, not part of the path.
+func sqliteFilePath(dsn string) (string, bool) {
+	if dsn == "" || strings.Contains(dsn, "://") {
+		return "", false
+	}
+
+	path, rawQuery := dsn, ""
+	if i := strings.IndexRune(dsn, '?'); i >= 0 {
+		path, rawQuery = dsn[:i], dsn[i+1:]
+	}
+
+	if strings.HasPrefix(path, "file:") {
+		path = strings.TrimPrefix(path, "file:")
+
+		// SQLite percent-decodes URI filenames, ordinary paths are taken as
+		// they are.
+		unescaped, err := url.PathUnescape(path)
+		if err != nil {
+			return "", false
+		}
+		path = unescaped
+	}
+
+	if path == "" || strings.HasPrefix(path, ":memory:") {
+		return "", false
+	}
+
+	if query, err := url.ParseQuery(rawQuery); err == nil && query.Get("mode") == "memory" {
+		return "", false
+	}
+
+	return path, true
+}
+
+// sqliteDSN adds the driver options raymond wants on every connection to dsn.
+// PRAGMA foreign_keys only lasts for a single connection, so enabling it
+// through the DSN is the only way to have the whole pool enforce them. An
+// option already present in dsn is left alone.
+func sqliteDSN(dsn string, foreignKeys bool) string {
+	if !foreignKeys {
+		return dsn
+	}
+
+	rawQuery := ""
+	if i := strings.IndexRune(dsn, '?'); i >= 0 {
+		rawQuery = dsn[i+1:]
+	}
+
+	if query, err := url.ParseQuery(rawQuery); err == nil && (query.Has("_foreign_keys") || query.Has("_fk")) {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.ContainsRune(dsn, '?') {
+		separator = "&"
+	}
+
+	return dsn + separator + "_foreign_keys=1"
+}
+
+// supportsStrictTables reports whether the SQLite library is recent enough
+// for STRICT tables, which arrived in 3.37.0.
+func supportsStrictTables(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var version string
+	if err := tx.QueryRowContext(ctx, `SELECT sqlite_version()`).Scan(&version); err != nil {
+		return false, err
+	}
+
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false, fmt.Errorf("parsing SQLite version %q: %w", version, err)
+	}
+
+	return major > 3 || (major == 3 && minor >= 37), nil
+}
+
+// prepareDatabasePath makes sure the SQLite database file can be created.
+// sql.Open connects lazily, so without this a missing directory only shows up
+// as a confusing error on the first query.
+func prepareDatabasePath(dsn string) error {
+	path, ok := sqliteFilePath(dsn)
+	if !ok {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating database directory %s: %w", dir, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("database file %s is not writable: %w", path, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing database file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// compactDatabase folds the write-ahead log back into the SQLite file and
+// rebuilds it without the pages left behind by deleted rows.
+func compactDatabase(ctx context.Context, db *sql.DB, dsn string) error {
+	before, sized := databaseSize(dsn)
+
+	if _, err := db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("checkpointing database: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuuming database: %w", err)
+	}
+
+	if after, ok := databaseSize(dsn); sized && ok {
+		log.Printf("Database compacted from %dB to %dB", before, after)
+	} else {
+		log.Println("Database compacted")
+	}
+
+	return nil
+}
+
+// databaseSize returns the on-disk size of a SQLite database including its
+// write-ahead log, if it has one.
+func databaseSize(dsn string) (int64, bool) {
+	path, ok := sqliteFilePath(dsn)
+	if !ok {
+		return 0, false
+	}
+
+	var size int64
+	for _, name := range []string{path, path + "-wal"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return 0, false
+		}
+
+		size += info.Size()
+	}
+
+	return size, true
+}
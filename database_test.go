@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteFilePath(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		wantPath string
+		wantOK   bool
+	}{
+		{dsn: "./db.sqlite", wantPath: "./db.sqlite", wantOK: true},
+		{dsn: "./db.sqlite?cache=shared&_busy_timeout=5000", wantPath: "./db.sqlite", wantOK: true},
+		{dsn: "file:data/db.sqlite?_journal_mode=WAL", wantPath: "data/db.sqlite", wantOK: true},
+		{dsn: "file:my%20db.sqlite", wantPath: "my db.sqlite", wantOK: true},
+		{dsn: ":memory:"},
+		{dsn: "file::memory:?cache=shared"},
+		{dsn: "file:test?mode=memory&cache=shared"},
+		{dsn: "postgres://user@localhost/raymond?sslmode=disable"},
+		{dsn: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			path, ok := sqliteFilePath(tt.dsn)
+			if path != tt.wantPath || ok != tt.wantOK {
+				t.Errorf("expected %q, %v, got %q, %v", tt.wantPath, tt.wantOK, path, ok)
+			}
+		})
+	}
+}
+
+func TestPrepareDatabasePathWithQuery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nested", "db.sqlite")
+
+	if err := prepareDatabasePath(path + "?cache=shared&_busy_timeout=5000"); err != nil {
+		t.Fatalf("preparing database path: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the database file to be created without the query: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("reading directory: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("expected only the database file, got %d entries", len(entries))
+	}
+}
+
+func TestSqliteDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{dsn: "./db.sqlite", want: "./db.sqlite?_foreign_keys=1"},
+		{dsn: "./db.sqlite?_busy_timeout=5000", want: "./db.sqlite?_busy_timeout=5000&_foreign_keys=1"},
+		{dsn: "./db.sqlite?_fk=0", want: "./db.sqlite?_fk=0"},
+		{dsn: "file:db.sqlite?_foreign_keys=off", want: "file:db.sqlite?_foreign_keys=off"},
+	}
+
+	for _, tt := range tests {
+		if got := sqliteDSN(tt.dsn, true); got != tt.want {
+			t.Errorf("expected %q for %q, got %q", tt.want, tt.dsn, got)
+		}
+	}
+
+	if got := sqliteDSN("./db.sqlite", false); got != "./db.sqlite" {
+		t.Errorf("expected the DSN to be left alone without foreign keys, got %q", got)
+	}
+}
+
+func TestForeignKeysAndStrictTables(t *testing.T) {
+	deps := newTestDeps(t)
+
+	// newTestDeps enables foreign keys.
+	_, err := deps.DB.Exec(`INSERT INTO redo_stack (event_id, name) VALUES (42, 'sorry')`)
+	if err == nil {
+		t.Error("expected a redo entry of a missing event to be rejected")
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "strict.sqlite"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	strict := &Deps{DB: db, StrictTables: true}
+	if err := strict.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO redo_stack (event_id, name) VALUES ('forty-two', 'sorry')`)
+	if err == nil {
+		t.Error("expected a STRICT table to reject text in an integer column")
+	}
+}
+
+func TestReadDB(t *testing.T) {
+	deps := newTestDeps(t)
+	replica := newTestDeps(t)
+	deps.ReadDB = replica.DB
+
+	// The replica has not caught up with the increment yet.
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	if body := doList(t, deps); body["counter"] != float64(0) {
+		t.Errorf("expected List to read the replica, got %v", body["counter"])
+	}
+
+	doAdd(t, replica)
+	createAggregate(t, replica)
+
+	if body := doList(t, deps); body["counter"] != float64(1) {
+		t.Errorf("expected List to see the replica catch up, got %v", body["counter"])
+	}
+
+	var primary int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&primary); err != nil {
+		t.Fatalf("counting events: %v", err)
+	}
+
+	if primary != 1 {
+		t.Errorf("expected writes to go to the primary only, got %d events", primary)
+	}
+}
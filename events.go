@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errEventNotFound = errors.New("event not found")
+
+// DeleteEvent removes a single event, addressed as /api/events/{id}, and
+// recomputes the aggregate of the counter it belonged to. Unlike Subtract
+// the row is really deleted, this is meant for correcting mistakes.
+func (d *Deps) DeleteEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/events/"), 10, 64)
+	if err != nil || id < 1 {
+		writeProblem(w, http.StatusNotFound, "event not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	var name string
+	err = d.retryTx(ctx, func() error {
+		var err error
+		name, err = d.deleteEvent(ctx, id)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errEventNotFound) {
+			writeProblem(w, http.StatusNotFound, "event not found")
+			return
+		}
+
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.CreateAggregate(ctx, name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "the event was deleted but aggregating failed: "+err.Error())
+		return
+	}
+
+	log.Printf("Deleted event %d of %s", id, name)
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{"message": "success"})
+}
+
+// deleteEvent deletes the event with the given id and returns the name of its
+// counter, or errEventNotFound when there is no such event.
+func (d *Deps) deleteEvent(ctx context.Context, id int64) (string, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = tx.QueryRowContext(ctx, `SELECT name FROM counter WHERE id = ?`, id).Scan(&name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return "", e
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errEventNotFound
+		}
+
+		return "", err
+	}
+
+	for _, statement := range []string{
+		`DELETE FROM redo_stack WHERE event_id = ?`,
+		`DELETE FROM counter WHERE id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			if e := tx.Rollback(); e != nil {
+				return "", e
+			}
+
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Count serves /api/count, the total as of a point in time on GET and setting
+// it on POST, which takes the API key.
+func (d *Deps) Count(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		d.CountAsOf(w, r)
+	case http.MethodPost:
+		d.RequireAPIKey(d.SetCount)(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// SetCount sets the total of the counter picked by ?name= to the count in a
+// {"count":N} body by recording a single adjustment event for the difference,
+// which keeps the history intact. Meant for migrating from another system.
+// Adjustments count towards the total but not towards the event statistics.
+func (d *Deps) SetCount(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var request struct {
+		Count *int `json:"count"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		if isBodyTooLarge(err) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		writeValidationErrors(w, decodeErrors(err))
+		return
+	}
+
+	if request.Count == nil {
+		writeValidationErrors(w, map[string]string{"count": "is required"})
+		return
+	}
+
+	if *request.Count < 0 {
+		writeValidationErrors(w, map[string]string{"count": "must not be negative"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	var delta int
+	err = d.retryTx(ctx, func() error {
+		var err error
+		delta, err = d.adjustCount(ctx, name, *request.Count)
+		return err
+	})
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.CreateAggregate(ctx, name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "the count was set but aggregating failed: "+err.Error())
+		return
+	}
+
+	log.Printf("Set the count of %s to %d, adjusted by %d", name, *request.Count, delta)
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message": "success",
+		"count":   *request.Count,
+		"delta":   delta,
+	})
+}
+
+// adjustCount records an event that brings the total of the named counter to
+// target and returns its count. Nothing is recorded when the total is already
+// right.
+func (d *Deps) adjustCount(ctx context.Context, name string, target int) (int, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, err
+	}
+
+	counts, err := liveTotal(ctx, tx, name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	delta := target - counts
+	if delta == 0 {
+		return 0, tx.Rollback()
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO counter (name, count, created_at, reason, adjustment) VALUES (?, ?, ?, ?, 1)`,
+		name,
+		delta,
+		d.now(),
+		"adjusted to "+strconv.Itoa(target),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	// Like a fresh increment, this starts a new history.
+	_, err = tx.ExecContext(ctx, `DELETE FROM redo_stack WHERE name = ?`, name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return delta, nil
+}
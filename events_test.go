@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeleteEvent(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	silenceLog(t)
+
+	handler := deps.RequireAPIKey(deps.DeleteEvent)
+	deleteEvent := func(id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/events/"+id, nil)
+		req.Header.Set("X-API-Key", "secret")
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+	doAdd(t, deps)
+
+	if code := deleteEvent("2"); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	var ids []int64
+	rows, err := deps.DB.Query(`SELECT id FROM counter ORDER BY id`)
+	if err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("reading counter: %v", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("expected events 1 and 3 to be left, got %v", ids)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(2) {
+		t.Errorf("expected the aggregate to be recomputed to 2, got %v", body["counter"])
+	}
+
+	for _, id := range []string{"2", "42", "abc"} {
+		if code := deleteEvent(id); code != http.StatusNotFound {
+			t.Errorf("expected status %d deleting %s, got %d", http.StatusNotFound, id, code)
+		}
+	}
+}
+
+func TestSetCount(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	silenceLog(t)
+
+	handler := deps.RequireAPIKey(deps.SetCount)
+	setCount := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/count", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+
+		return rec.Code, response
+	}
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+
+	for _, tt := range []struct {
+		count int
+		delta float64
+	}{
+		{count: 100, delta: 98},
+		{count: 40, delta: -60},
+		{count: 40, delta: 0},
+	} {
+		code, body := setCount(`{"count":` + strconv.Itoa(tt.count) + `}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %v", http.StatusOK, code, body)
+		}
+
+		if body["delta"] != tt.delta {
+			t.Errorf("expected a delta of %v setting %d, got %v", tt.delta, tt.count, body["delta"])
+		}
+
+		if list := doList(t, deps); list["counter"] != float64(tt.count) {
+			t.Errorf("expected the counter to be %d, got %v", tt.count, list["counter"])
+		}
+	}
+
+	var rows int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&rows); err != nil {
+		t.Fatalf("counting events: %v", err)
+	}
+
+	if rows != 4 {
+		t.Errorf("expected two adjustments next to the two increments, got %d events", rows)
+	}
+
+	for _, body := range []string{`{"count":-1}`, `{}`, `{"count":"ten"}`} {
+		if code, _ := setCount(body); code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, code)
+		}
+	}
+
+	deps.MaxBodyBytes = 16
+	req := httptest.NewRequest(http.MethodPost, "/api/count", strings.NewReader(`{"count":`+strings.Repeat("1", 32)+`}`))
+	req.Header.Set("X-API-Key", "secret")
+	req.ContentLength = -1
+
+	rec := httptest.NewRecorder()
+	deps.LimitBody(handler).ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d for a body over the limit, got %d: %s", http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	}
+}
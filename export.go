@@ -0,0 +1,254 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// checksumTrailer carries the SHA-256 of an export once it was written
+// completely. A download that lacks it was cut short.
+const checksumTrailer = "X-Content-SHA256"
+
+// Export streams every live event of the counter picked by ?name=, oldest
+// first, as ?format=csv (the default, in the format Import reads) or json.
+// ?gzip=true compresses the response. The checksumTrailer is computed over
+// the uncompressed payload, which is what a client sees after decoding.
+func (d *Deps) Export(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	if format != "csv" && format != "json" {
+		writeProblem(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	compress := false
+	if v := query.Get("gzip"); v != "" {
+		compress, err = strconv.ParseBool(v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "gzip must be a boolean")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute*5)
+	defer cancel()
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT id, count, created_at, reason FROM counter
+			WHERE name = ? AND deleted_at IS NULL
+			ORDER BY julianday(created_at), id`,
+		name,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "json" {
+		contentType = "application/json"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+format+`"`)
+	w.Header().Set("Trailer", checksumTrailer)
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+
+	hash := sha256.New()
+	out = io.MultiWriter(out, hash)
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Once the status is out, a failure can only be logged. The missing
+	// trailer tells the client that the export is incomplete.
+	err = d.writeExport(r, out, format, rows)
+	if gz != nil {
+		if e := gz.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		log.Printf("exporting %s: %v", name, err)
+		return
+	}
+
+	w.Header().Set(checksumTrailer, hex.EncodeToString(hash.Sum(nil)))
+}
+
+func (d *Deps) writeExport(r *http.Request, out io.Writer, format string, rows *sql.Rows) error {
+	if format == "json" {
+		return d.writeJSONExport(r, out, rows)
+	}
+
+	writer := csv.NewWriter(out)
+	if err := writer.Write([]string{"count", "created_at"}); err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var event historyEvent
+		var reason sql.NullString
+		if err := rows.Scan(&event.ID, &event.Count, &event.CreatedAt, &reason); err != nil {
+			return err
+		}
+
+		if err := writer.Write([]string{strconv.Itoa(event.Count), event.CreatedAt.Format(time.RFC3339)}); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJSONExport writes the events as a JSON array of history events, one
+// at a time so a large counter is never held in memory.
+func (d *Deps) writeJSONExport(r *http.Request, out io.Writer, rows *sql.Rows) error {
+	if _, err := io.WriteString(out, "["); err != nil {
+		return err
+	}
+
+	for first := true; rows.Next(); first = false {
+		var event historyEvent
+		var reason sql.NullString
+		if err := rows.Scan(&event.ID, &event.Count, &event.CreatedAt, &reason); err != nil {
+			return err
+		}
+		event.Reason = reason.String
+
+		b, err := d.marshalJSON(r, event)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			b = append([]byte(","), b...)
+		}
+
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(out, "]\n")
+	return err
+}
+
+// jsonLinesFlushEvery is how many lines EventsJSONLines writes between
+// flushes, so a consumer sees a large counter arrive bit by bit.
+const jsonLinesFlushEvery = 100
+
+// EventsJSONLines streams every live event of the counter picked by ?name=,
+// oldest first, as JSON Lines: one compact object per line, the way jq and
+// log processors read them. It stops as soon as the client goes away.
+func (d *Deps) EventsJSONLines(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute*5)
+	defer cancel()
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT id, count, created_at, reason FROM counter
+			WHERE name = ? AND deleted_at IS NULL
+			ORDER BY julianday(created_at), id`,
+		name,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	// Once the status is out, a failure can only be logged and the stream
+	// cut short.
+	for n := 1; rows.Next(); n++ {
+		var event historyEvent
+		var reason sql.NullString
+		if err := rows.Scan(&event.ID, &event.Count, &event.CreatedAt, &reason); err != nil {
+			log.Printf("streaming events of %s: %v", name, err)
+			return
+		}
+		event.Reason = reason.String
+
+		b, err := d.marshalCompactJSON(event)
+		if err != nil {
+			log.Printf("streaming events of %s: %v", name, err)
+			return
+		}
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return
+		}
+
+		if flusher != nil && n%jsonLinesFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	// A client that went away shows up as a cancelled context here.
+	if err := rows.Err(); err != nil && ctx.Err() == nil {
+		log.Printf("streaming events of %s: %v", name, err)
+	}
+}
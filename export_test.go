@@ -0,0 +1,125 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	deps := newTestDeps(t)
+
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+
+	export := func(query string) (*http.Response, string) {
+		rec := httptest.NewRecorder()
+		deps.Export(rec, httptest.NewRequest(http.MethodGet, "/api/export"+query, nil))
+
+		resp := rec.Result()
+		var body io.Reader = resp.Body
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatalf("reading gzip: %v", err)
+			}
+			body = gz
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("reading export: %v", err)
+		}
+
+		return resp, string(b)
+	}
+
+	checksum := func(body string) string {
+		sum := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(sum[:])
+	}
+
+	resp, body := export("")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+
+	want := "count,created_at\n1,2022-07-18T08:00:00Z\n1,2022-07-19T08:00:00Z\n"
+	if body != want {
+		t.Errorf("expected %q, got %q", want, body)
+	}
+
+	if got := resp.Trailer.Get(checksumTrailer); got != checksum(body) {
+		t.Errorf("expected checksum %s, got %q", checksum(body), got)
+	}
+
+	// An export can be imported again.
+	if _, err := deps.parseImport(strings.NewReader(body)); err != nil {
+		t.Errorf("expected the export to import, got %v", err)
+	}
+
+	resp, body = export("?format=json&gzip=true")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Error("expected a gzip encoded export")
+	}
+
+	var events []historyEvent
+	if err := json.Unmarshal([]byte(body), &events); err != nil {
+		t.Fatalf("decoding export %s: %v", body, err)
+	}
+
+	if len(events) != 2 || events[0].ID != 2 || events[1].ID != 1 {
+		t.Errorf("expected both events oldest first, got %v", events)
+	}
+
+	if got := resp.Trailer.Get(checksumTrailer); got != checksum(body) {
+		t.Errorf("expected checksum %s over the uncompressed export, got %q", checksum(body), got)
+	}
+
+	for _, query := range []string{"?format=xml", "?gzip=maybe"} {
+		if resp, _ := export(query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, query, resp.StatusCode)
+		}
+	}
+}
+
+func TestEventsJSONLines(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.SnakeCaseJSON = true
+
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+
+	rec := httptest.NewRecorder()
+	deps.EventsJSONLines(rec, httptest.NewRequest(http.MethodGet, "/api/events.jsonl?pretty=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("expected JSON Lines, got %q", got)
+	}
+
+	// Even asked for pretty JSON, every event stays on a line of its own.
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", rec.Body.String())
+	}
+
+	for i, want := range []string{"2022-07-18T08:00:00Z", "2022-07-19T08:00:00Z"} {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatalf("decoding line %d: %v", i+1, err)
+		}
+
+		if event["created_at"] != want {
+			t.Errorf("expected line %d to be the event at %s, got %v", i+1, want, event)
+		}
+	}
+}
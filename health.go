@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// aggregateHealth compares the aggregate of a counter with its live events.
+type aggregateHealth struct {
+	Name      string `json:"name"`
+	Aggregate int    `json:"aggregate"`
+	Live      int    `json:"live"`
+	Drift     int    `json:"drift"`
+	// AggregatedAt is nil for a counter that was never aggregated.
+	AggregatedAt  *time.Time `json:"aggregatedAt"`
+	LatestEventAt time.Time  `json:"latestEventAt"`
+	// Lag is how many seconds ago the oldest event the aggregate misses
+	// happened, zero when it is up to date.
+	Lag      float64 `json:"lag"`
+	Degraded bool    `json:"degraded"`
+}
+
+// Ready answers readiness probes. Besides pinging the database, and the
+// replica if there is one, it checks that the aggregate of every counter
+// keeps up with its events: a counter whose aggregate is off by more than
+// ReadyMaxDrift, or misses events older than ReadyMaxLag, makes the instance
+// degraded and the response a 503. The details of every counter are in the
+// body either way.
+func (d *Deps) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	dbs := []*sql.DB{d.DB}
+	if d.ReadDB != nil {
+		dbs = append(dbs, d.ReadDB)
+	}
+
+	for _, db := range dbs {
+		if err := db.PingContext(ctx); err != nil {
+			d.writeJSON(w, r, http.StatusServiceUnavailable, map[string]interface{}{
+				"status": "unavailable",
+				"error":  err.Error(),
+			})
+			return
+		}
+	}
+
+	// Without an aggregate there is nothing that could fall behind.
+	if d.AggregateMode == AggregateNone {
+		d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"status":    "ok",
+			"aggregate": AggregateNone,
+		})
+		return
+	}
+
+	counters, err := d.aggregateHealth(ctx)
+	if err != nil {
+		d.writeJSON(w, r, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unavailable",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	status := http.StatusOK
+	body := map[string]interface{}{
+		"status":   "ok",
+		"counters": counters,
+	}
+
+	for _, counter := range counters {
+		if counter.Degraded {
+			status = http.StatusServiceUnavailable
+			body["status"] = "degraded"
+		}
+	}
+
+	d.writeJSON(w, r, status, body)
+}
+
+func (d *Deps) aggregateHealth(ctx context.Context) ([]aggregateHealth, error) {
+	c, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(ctx, `SELECT DISTINCT name FROM counter WHERE deleted_at IS NULL ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			if e := rows.Close(); e != nil {
+				log.Println(e)
+			}
+
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err := rows.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := d.now()
+	counters := []aggregateHealth{}
+	for _, name := range names {
+		health := aggregateHealth{Name: name}
+
+		err := c.QueryRowContext(ctx, liveAggregateQuery, name).Scan(&health.Live, &health.LatestEventAt)
+		if err != nil {
+			return nil, err
+		}
+
+		var aggregatedAt time.Time
+		err = c.QueryRowContext(
+			ctx,
+			`SELECT counts, created_at FROM counter_aggregate WHERE name = ? ORDER BY julianday(created_at) DESC LIMIT 1`,
+			name,
+		).Scan(&health.Aggregate, &aggregatedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		if !aggregatedAt.IsZero() {
+			health.AggregatedAt = &aggregatedAt
+		}
+
+		health.Drift = health.Live - health.Aggregate
+		if health.Drift < 0 {
+			health.Drift = -health.Drift
+		}
+
+		// The oldest event the aggregate misses shows how long the aggregator
+		// has been behind. Backfilled events are older than the aggregate
+		// that misses them, only the drift gives those away.
+		var missedAt time.Time
+		err = c.QueryRowContext(
+			ctx,
+			`SELECT created_at FROM counter
+				WHERE name = ? AND deleted_at IS NULL AND julianday(created_at) > julianday(?)
+				ORDER BY julianday(created_at) LIMIT 1`,
+			name,
+			aggregatedAt,
+		).Scan(&missedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var lag time.Duration
+		if !missedAt.IsZero() {
+			lag = now.Sub(missedAt)
+			health.Lag = lag.Seconds()
+		}
+
+		health.Degraded = health.Drift > d.ReadyMaxDrift || lag > d.ReadyMaxLag
+
+		counters = append(counters, health)
+	}
+
+	return counters, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.ReadyMaxLag = time.Minute
+	deps.ReadyMaxDrift = 1
+	silenceLog(t)
+
+	now := time.Date(2022, time.July, 20, 12, 0, 0, 0, time.UTC)
+	deps.Now = func() time.Time { return now }
+
+	ready := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		deps.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding body: %v", err)
+		}
+
+		return rec.Code, body
+	}
+
+	if code, body := ready(); code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("expected an empty database to be ready, got %d %v", code, body)
+	}
+
+	// Fresh events the aggregator did not get to yet are fine.
+	addAt(t, deps, "2022-07-20T11:59:30Z")
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expected a short lag to be tolerated, got %d %v", code, body)
+	}
+
+	now = now.Add(time.Minute)
+	code, body := ready()
+	if code != http.StatusServiceUnavailable || body["status"] != "degraded" {
+		t.Fatalf("expected a stale aggregate to be degraded, got %d %v", code, body)
+	}
+
+	counter := body["counters"].([]interface{})[0].(map[string]interface{})
+	if counter["name"] != "sorry" || counter["live"] != float64(1) || counter["aggregate"] != float64(0) || counter["lag"] != float64(90) {
+		t.Errorf("unexpected details %v", counter)
+	}
+
+	createAggregate(t, deps)
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expected a fresh aggregate to be ready, got %d %v", code, body)
+	}
+
+	// Backfilled events do not show up as lag, only as drift.
+	addAt(t, deps, "2022-07-01T00:00:00Z")
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("expected a drift of 1 to be tolerated, got %d", code)
+	}
+
+	addAt(t, deps, "2022-07-01T00:00:00Z")
+	if code, _ := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected a drift of 2 to be degraded, got %d", code)
+	}
+
+	deps.AggregateMode = AggregateNone
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("expected no aggregate to never be degraded, got %d", code)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// historyEvent is a single live event as listed by History.
+type historyEvent struct {
+	ID        int64     `json:"id"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"createdAt"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// History lists the raw events of the counter picked by ?name=, newest first.
+// ?from= and ?to= are inclusive RFC3339 bounds on the event time, either can
+// be left out. ?limit= and ?offset= page through the result.
+func (d *Deps) History(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit := defaultHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			writeProblem(w, http.StatusBadRequest, "limit must be a number between 1 and "+strconv.Itoa(maxHistoryLimit))
+			return
+		}
+	}
+
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeProblem(w, http.StatusBadRequest, "offset must be a non-negative number")
+			return
+		}
+	}
+
+	where := `name = ? AND deleted_at IS NULL`
+	args := []interface{}{name}
+
+	var from, to time.Time
+	if v := query.Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "from must be an RFC3339 timestamp")
+			return
+		}
+
+		where += ` AND julianday(created_at) >= julianday(?)`
+		args = append(args, from)
+	}
+
+	if v := query.Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "to must be an RFC3339 timestamp")
+			return
+		}
+
+		where += ` AND julianday(created_at) <= julianday(?)`
+		args = append(args, to)
+	}
+
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		writeProblem(w, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	// Timestamps are compared through julianday since they may have been
+	// written with any offset.
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT id, count, created_at, reason FROM counter WHERE `+where+`
+			ORDER BY julianday(created_at) DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	events := []historyEvent{}
+	for rows.Next() {
+		var event historyEvent
+		var reason sql.NullString
+		if err := rows.Scan(&event.ID, &event.Count, &event.CreatedAt, &reason); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		event.Reason = reason.String
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, events)
+}
+
+// nthEvent is the answer of NthEvent.
+type nthEvent struct {
+	N int `json:"n"`
+	historyEvent
+}
+
+// NthEvent returns the nth live event of the counter picked by ?name=,
+// addressed as /api/events/nth/{n} and counted from 1, oldest first. It
+// counts rows: an event with a count of 3 is still a single one. Adjustments
+// recorded by SetCount are not events and are skipped.
+func (d *Deps) NthEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/events/nth/"))
+	if err != nil || n < 1 {
+		writeProblem(w, http.StatusNotFound, "event not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	event := nthEvent{N: n}
+	var reason sql.NullString
+	err = d.readDB().QueryRowContext(
+		ctx,
+		`SELECT id, count, created_at, reason FROM counter
+			WHERE name = ? AND deleted_at IS NULL AND adjustment = 0
+			ORDER BY julianday(created_at), id LIMIT 1 OFFSET ?`,
+		name,
+		n-1,
+	).Scan(&event.ID, &event.Count, &event.CreatedAt, &reason)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, http.StatusNotFound, "event not found")
+			return
+		}
+
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	event.Reason = reason.String
+
+	d.writeJSON(w, r, http.StatusOK, event)
+}
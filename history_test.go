@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	deps := newTestDeps(t)
+	addAt(t, deps, "2022-07-17T08:00:00Z")
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+	addAt(t, deps, "2022-07-19T08:00:00+07:00")
+	addAt(t, deps, "2022-07-20T08:00:00Z")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{name: "everything", query: "", wantStatus: http.StatusOK, wantIDs: []int64{4, 3, 2, 1}},
+		{name: "inclusive range", query: "?from=2022-07-18T08:00:00Z&to=2022-07-19T01:00:00Z", wantStatus: http.StatusOK, wantIDs: []int64{3, 2}},
+		{name: "only from", query: "?from=2022-07-19T00:00:00Z", wantStatus: http.StatusOK, wantIDs: []int64{4, 3}},
+		{name: "paginated range", query: "?from=2022-07-17T00:00:00Z&to=2022-07-21T00:00:00Z&limit=2&offset=1", wantStatus: http.StatusOK, wantIDs: []int64{3, 2}},
+		{name: "empty range", query: "?from=2021-01-01T00:00:00Z&to=2021-12-31T00:00:00Z", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "from after to", query: "?from=2022-07-20T00:00:00Z&to=2022-07-18T00:00:00Z", wantStatus: http.StatusBadRequest},
+		{name: "malformed from", query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "limit too large", query: "?limit=501", wantStatus: http.StatusBadRequest},
+		{name: "negative offset", query: "?offset=-1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			deps.History(rec, httptest.NewRequest(http.MethodGet, "/api/history"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var events []historyEvent
+			if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			ids := []int64{}
+			for _, event := range events {
+				ids = append(ids, event.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("expected events %v, got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+}
+
+func TestNthEvent(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+	addAt(t, deps, "2022-07-20T08:00:00Z")
+
+	// Backfilled, so it is the first event although it was added last.
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"at":"2022-07-17T08:00:00Z","reason":"late"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	handler := deps.Handler(deps.Routes())
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantID     int64
+		wantReason string
+	}{
+		{path: "/api/events/nth/1", wantStatus: http.StatusOK, wantID: 3, wantReason: "late"},
+		{path: "/api/events/nth/3", wantStatus: http.StatusOK, wantID: 2},
+		{path: "/api/events/nth/4", wantStatus: http.StatusNotFound},
+		{path: "/api/events/nth/0", wantStatus: http.StatusNotFound},
+		{path: "/api/events/nth/-1", wantStatus: http.StatusNotFound},
+		{path: "/api/events/nth/first", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var event nthEvent
+			if err := json.Unmarshal(rec.Body.Bytes(), &event); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if event.ID != tt.wantID || event.Reason != tt.wantReason {
+				t.Errorf("expected event %d with reason %q, got %+v", tt.wantID, tt.wantReason, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// updateHub tells waiting readers that the aggregate changed. Readers take the
+// channel from wait before reading the current value and get it closed on the
+// next publish, so no update can slip in between. Every publish also gets an
+// id, see waitID. Its zero value is ready to use.
+type updateHub struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	done    chan struct{}
+	id      int64
+}
+
+func (h *updateHub) wait() <-chan struct{} {
+	changed, _ := h.waitID()
+	return changed
+}
+
+// waitID is wait that also returns the id of the latest publish. Ids count up
+// from the time the hub was first used, so they keep increasing across
+// restarts and an id from an earlier process is not mistaken for a current
+// one.
+func (h *updateHub) waitID() (<-chan struct{}, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.changed == nil {
+		h.changed = make(chan struct{})
+	}
+
+	if h.id == 0 {
+		h.id = time.Now().UnixNano()
+	}
+
+	return h.changed, h.id
+}
+
+func (h *updateHub) publish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.id == 0 {
+		h.id = time.Now().UnixNano()
+	}
+	h.id++
+
+	if h.changed != nil {
+		close(h.changed)
+		h.changed = nil
+	}
+}
+
+// closed returns a channel that is closed once the hub shuts down, telling
+// waiting readers to answer with what they have instead of holding on.
+func (h *updateHub) closed() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done == nil {
+		h.done = make(chan struct{})
+	}
+
+	return h.done
+}
+
+// close releases every waiting reader for good. It is registered with
+// server.Shutdown, which otherwise waits for long polls to run out.
+func (h *updateHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done == nil {
+		h.done = make(chan struct{})
+	}
+
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type importRow struct {
+	count     int
+	createdAt time.Time
+}
+
+// Import loads historical events from a CSV file uploaded as the "file" field
+// of a multipart form into the counter picked by ?name=. Each row holds a
+// count and an RFC3339 created_at, an optional header row is skipped. Either
+// every row is imported or none is.
+func (d *Deps) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		writeProblem(w, http.StatusBadRequest, "expected a multipart form with a CSV in the file field")
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	rows, err := d.parseImport(file)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	err = d.retryTx(ctx, func() error {
+		return d.insertImport(ctx, name, rows)
+	})
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.CreateAggregate(ctx, name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "events were imported but aggregating failed: "+err.Error())
+		return
+	}
+
+	log.Printf("Imported %d events into %s", len(rows), name)
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"imported": len(rows),
+	})
+}
+
+// insertImport writes rows into the named counter in a single transaction.
+func (d *Deps) insertImport(ctx context.Context, name string, rows []importRow) error {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO counter (name, count, created_at) VALUES (?, ?, ?)`,
+			name,
+			row.count,
+			row.createdAt,
+		)
+		if err != nil {
+			if e := tx.Rollback(); e != nil {
+				return e
+			}
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// parseImport reads and validates every row of an import CSV, failing on the
+// first malformed one.
+func (d *Deps) parseImport(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	now := d.now()
+
+	var rows []importRow
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		if line == 1 && strings.EqualFold(record[0], "count") && strings.EqualFold(record[1], "created_at") {
+			continue
+		}
+
+		count, err := strconv.Atoi(record[0])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("line %d: count must be a positive integer", line)
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: created_at must be an RFC3339 timestamp", line)
+		}
+
+		if createdAt.After(now) {
+			return nil, fmt.Errorf("line %d: created_at must not be in the future", line)
+		}
+
+		rows = append(rows, importRow{count: count, createdAt: createdAt})
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("the file does not contain any rows")
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func importRequest(t *testing.T, csv string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("file", "events.csv")
+	if err != nil {
+		t.Fatalf("creating form file: %v", err)
+	}
+
+	if _, err := part.Write([]byte(csv)); err != nil {
+		t.Fatalf("writing form file: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("closing multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestImport(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	silenceLog(t)
+
+	handler := deps.RequireAPIKey(deps.Import)
+
+	rec := httptest.NewRecorder()
+	handler(rec, importRequest(t, "count,created_at\n1,2022-07-18T08:00:00Z\n2,2022-07-19T08:00:00+07:00\n"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	if body["imported"] != float64(2) {
+		t.Errorf("expected 2 imported rows, got %v", body["imported"])
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(3) {
+		t.Errorf("expected the aggregate to be recomputed to 3, got %v", body["counter"])
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, importRequest(t, "5,2022-07-17T08:00:00Z\n1,last tuesday\n"))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for a malformed row, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if got := rec.Body.String(); !strings.Contains(got, `"detail":"line 2: created_at must be an RFC3339 timestamp"`) {
+		t.Errorf("unexpected error body %s", got)
+	}
+
+	var rows int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&rows); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+
+	if rows != 2 {
+		t.Errorf("expected the malformed file to be rejected as a whole, got %d rows", rows)
+	}
+}
+
+func TestImportRequiresAPIKey(t *testing.T) {
+	deps := newTestDeps(t)
+	handler := deps.RequireAPIKey(deps.Import)
+
+	rec := httptest.NewRecorder()
+	handler(rec, importRequest(t, "1,2022-07-18T08:00:00Z\n"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without API_KEY configured, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	deps.APIKey = "another secret"
+
+	rec = httptest.NewRecorder()
+	handler(rec, importRequest(t, "1,2022-07-18T08:00:00Z\n"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with the wrong key, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
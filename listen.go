@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// listen binds addr for a server to Serve on. The usual reasons it fails get
+// an error that says what to do about them, rather than the bare syscall
+// error ListenAndServe would report.
+func listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err == nil {
+		return listener, nil
+	}
+
+	switch {
+	case errors.Is(err, syscall.EACCES):
+		return nil, fmt.Errorf("permission denied binding %s, try a port above 1024", addr)
+	case errors.Is(err, syscall.EADDRINUSE):
+		return nil, fmt.Errorf("%s is already in use, stop whatever listens there or pick another port", addr)
+	default:
+		return nil, fmt.Errorf("binding %s: %w", addr, err)
+	}
+}
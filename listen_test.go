@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	listener, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("binding a free port: %v", err)
+	}
+	defer listener.Close()
+
+	addr := listener.Addr().String()
+
+	_, err = listen(addr)
+	if err == nil {
+		t.Fatal("expected binding a taken port to fail")
+	}
+
+	if want := addr + " is already in use, stop whatever listens there or pick another port"; err.Error() != want {
+		t.Errorf("expected error %q, got %q", want, err)
+	}
+}
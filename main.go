@@ -3,41 +3,201 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Containers rarely ship a timezone database, TIMEZONE should work anyway.
+	_ "time/tzdata"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Hooks are optional callbacks run after events. Each one is called in its
+// own goroutine with a context that is cancelled on shutdown, so a hook must
+// not block for long and must not expect to finish when the server stops.
+type Hooks struct {
+	// OnAdd is called after an Add was committed with the amount that was
+	// added and the new total of the counter.
+	OnAdd func(ctx context.Context, count int, total int)
+}
+
 type Deps struct {
 	DB *sql.DB
+	// ReadDB is a replica that List and the statistics read from, DB is
+	// used when it is nil. Writes, aggregation and anything that has to see
+	// them right away stay on DB, so with a lagging replica List can show an
+	// older total even with ReadYourWrites.
+	ReadDB     *sql.DB
+	WebhookURL string
+	// WebhookBackoff is the delay before the second attempt at delivering a
+	// webhook, doubled for every further one. Zero means a second.
+	WebhookBackoff    time.Duration
+	AggregateDebounce time.Duration
+	// AggregateKeep is the number of most recent counter_aggregate rows kept
+	// by PruneAggregates.
+	AggregateKeep int
+	// AggregateMode decides when writes bring counter_aggregate up to date:
+	// AggregateAsync in the background, AggregateSync before responding, and
+	// AggregateNone never, List sums the counter table itself instead. Empty
+	// means AggregateAsync.
+	AggregateMode string
+	// ReadYourWrites makes writes in AggregateAsync wait for the aggregate
+	// that includes them before responding, so a List that follows never
+	// shows an older total. They still share the debounced aggregation.
+	ReadYourWrites bool
+	// TrustProxy makes clientIP look at X-Forwarded-For, skipping the hops
+	// that belong to TrustedProxies. With an empty TrustedProxies only the
+	// immediate peer is trusted.
+	TrustProxy     bool
+	TrustedProxies []*net.IPNet
+	// ForceHTTPSRedirect makes ForceHTTPS redirect requests that reached the
+	// proxy over plain HTTP. It needs TrustProxy.
+	ForceHTTPSRedirect bool
+	// MaxBodyBytes caps the size of every request body, see LimitBody.
+	// MaxImportBytes replaces it for CSV uploads to /api/import.
+	MaxBodyBytes   int64
+	MaxImportBytes int64
+	// BackupDir is where RunBackups writes database snapshots, of which the
+	// latest BackupKeep are kept.
+	BackupDir  string
+	BackupKeep int
+	// RequestTimeout is how long a request may take before Timeout answers
+	// it with 503, zero disables the limit.
+	RequestTimeout time.Duration
+	// DefaultWeight is the count of an Add that specifies neither amount nor
+	// weight. Defaults to 1 when zero.
+	DefaultWeight int
+	// TxMaxAttempts caps how often a write transaction is retried after a
+	// lock or serialization failure, see retryTx.
+	TxMaxAttempts int
+	// APIKey guards the admin endpoints, see RequireAPIKey.
+	APIKey string
+	// ContentSecurityPolicy is sent by SecurityHeaders, it defaults to
+	// defaultContentSecurityPolicy when empty.
+	ContentSecurityPolicy string
+	// BaseContext is the parent of the work handlers leave running in the
+	// background, such as aggregation and webhooks. main cancels it on
+	// shutdown. It defaults to context.Background when nil.
+	BaseContext context.Context
+	// Now returns the current time used for every timestamp the app writes.
+	// It defaults to time.Now when nil, tests replace it with a fixed clock.
+	Now func() time.Time
+
+	// Hooks lets code embedding the server run its own logic on events.
+	Hooks Hooks
+
+	// Location is the timezone statistics are bucketed in, so days and hours
+	// split where the people using the counter expect. Defaults to UTC.
+	Location *time.Location
+	// EnableWriteBuffer makes Add queue increments in memory when the
+	// database write fails, RunWriteBuffer replays them later. Queued
+	// increments are lost if the process dies before that.
+	EnableWriteBuffer bool
+	// IndexTemplate replaces the built-in page served by Index when set. It
+	// is executed with an IndexData.
+	IndexTemplate *template.Template
+	// Development adds the stack trace of a panic to the 500 response, see
+	// Recover. It is set by ENV=development.
+	Development bool
+	// AddCooldown is the minimum time between two increments of the same
+	// counter, Add answers 429 within it. Zero disables the cooldown.
+	AddCooldown time.Duration
+	// CookieSecret signs the anonymous visitor cookie set by Visitors.
+	// Increments are not attributed to visitors when it is empty.
+	CookieSecret string
+	// EnablePprof serves runtime profiles under /debug/pprof/ with the admin
+	// endpoints.
+	EnablePprof bool
+	// Isolation is the isolation level of every transaction, serializable
+	// when left at sql.LevelDefault. SQLite ignores it, on Postgres
+	// sql.LevelReadCommitted trades strictness for fewer retries.
+	Isolation sql.IsolationLevel
+	// ServerTimeHeader makes ServerTime send X-Server-Time with API
+	// responses.
+	ServerTimeHeader bool
+	// SnakeCaseJSON renames the keys of JSON responses from camelCase to
+	// snake_case, e.g. lastDate to last_date. Problem details keep theirs.
+	SnakeCaseJSON bool
+	// MilestoneEvery makes Add record a milestone whenever the total crosses
+	// a multiple of it, zero disables that.
+	MilestoneEvery int
+	// Subjects are the counter names the page lets visitors switch between,
+	// just the default counter when empty. Not to be confused with the
+	// people in knownSubjects.
+	Subjects []string
+	// StrictTables makes Migrate create new tables as STRICT where it can,
+	// so SQLite rejects values of the wrong type.
+	StrictTables bool
+	// Target is a goal for the count, List reports the progress towards it
+	// unless it is zero.
+	Target int
+	// ThemeMode is ThemeLight or ThemeDark, ThemeAccent a hex colour that
+	// replaces the accent of the mode when not empty.
+	ThemeMode   string
+	ThemeAccent string
+	// ListCacheTTL is how long List may serve the aggregate from memory,
+	// zero disables the cache. Writes invalidate it right away.
+	ListCacheTTL time.Duration
+	// ReadyMaxLag and ReadyMaxDrift are how far the aggregate may fall
+	// behind before Ready reports the instance as degraded.
+	ReadyMaxLag   time.Duration
+	ReadyMaxDrift int
+
+	aggregateOnce    sync.Once
+	aggregateSignals chan struct{}
+	aggregateMu      sync.Mutex
+	aggregatePending map[string][]chan error
+	listCache        listCache
+	updates          updateHub
+	metrics          requestMetrics
+	writeBuffer      writeBuffer
+	webhooks         webhookQueue
+	maintenance      int32
+	paused           int32
+	ogImages         ogImageCache
 }
 
 func main() {
-	log.Println("Server is starting up")
+	log.Printf("Server is starting up (version %s, commit %s, built %s)", version, commit, buildTime)
+
+	// The first argument may name a command, serve is the default.
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	port, ok := os.LookupEnv("PORT")
-	if !ok {
-		port = "80"
+	if command != "serve" && command != "migrate" {
+		log.Fatalf("unknown command %q, expected serve or migrate", command)
 	}
 
-	host, ok := os.LookupEnv("HOST")
-	if !ok {
-		host = "0.0.0.0"
+	config, err := LoadConfig(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+
+		log.Fatalln(err)
 	}
 
-	dbUrl, ok := os.LookupEnv("DATABASE_URL")
-	if !ok {
-		dbUrl = "./db.sqlite"
+	err = prepareDatabasePath(config.DatabaseURL)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	db, err := sql.Open("sqlite3", dbUrl)
+	db, err := sql.Open("sqlite3", sqliteDSN(config.DatabaseURL, config.SQLiteForeignKeys))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -48,51 +208,318 @@ func main() {
 		}
 	}()
 
-	deps := &Deps{DB: db}
+	// Without a replica the read handlers share db.
+	var readDB *sql.DB
+	if config.ReadDatabaseURL != "" {
+		readDB, err = sql.Open("sqlite3", sqliteDSN(config.ReadDatabaseURL, config.SQLiteForeignKeys))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer func() {
+			err := readDB.Close()
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		log.Println("Reading lists and statistics from READ_DATABASE_URL")
+	}
+
+	backgroundCtx, backgroundCancel := context.WithCancel(context.Background())
+	defer backgroundCancel()
 
-	log.Println("Migrating database in progress")
+	var indexTemplate *template.Template
+	if config.TemplateFile != "" {
+		indexTemplate = loadIndexTemplate(config.TemplateFile)
+	}
+
+	deps := &Deps{
+		BaseContext:           backgroundCtx,
+		DB:                    db,
+		ReadDB:                readDB,
+		WebhookURL:            config.WebhookURL,
+		AggregateDebounce:     time.Millisecond * time.Duration(config.AggregateDebounceMS),
+		AggregateKeep:         config.AggregateKeep,
+		ReadYourWrites:        config.ReadYourWrites,
+		AggregateMode:         config.AggregateMode,
+		TrustProxy:            config.TrustProxy,
+		TrustedProxies:        config.trustedProxies,
+		ForceHTTPSRedirect:    config.ForceHTTPS,
+		MaxBodyBytes:          config.MaxBodyBytes,
+		MaxImportBytes:        config.MaxImportBytes,
+		APIKey:                config.APIKey,
+		TxMaxAttempts:         config.TxMaxAttempts,
+		DefaultWeight:         config.DefaultWeight,
+		RequestTimeout:        time.Duration(config.RequestTimeout),
+		BackupDir:             config.BackupDir,
+		BackupKeep:            config.BackupKeep,
+		ContentSecurityPolicy: config.ContentSecurityPolicy,
+		ListCacheTTL:          time.Duration(config.ListCacheTTL),
+		ReadyMaxLag:           time.Duration(config.ReadyMaxLag),
+		ReadyMaxDrift:         config.ReadyMaxDrift,
+		AddCooldown:           time.Duration(config.AddCooldown),
+		MilestoneEvery:        config.MilestoneEvery,
+		Target:                config.Target,
+		ThemeMode:             config.ThemeMode,
+		ThemeAccent:           config.ThemeAccent,
+		StrictTables:          config.SQLiteStrict,
+		Subjects:              config.subjects,
+		CookieSecret:          config.CookieSecret,
+		SnakeCaseJSON:         config.JSONCase == "snake",
+		EnableWriteBuffer:     config.EnableWriteBuffer,
+		IndexTemplate:         indexTemplate,
+		Development:           config.Env == "development",
+		EnablePprof:           config.EnablePprof,
+		ServerTimeHeader:      config.ServerTimeHeader,
+		Isolation:             config.isolation,
+		Location:              config.location,
+	}
 
 	prepareCtx, prepareCancel := context.WithTimeout(context.Background(), time.Minute*1)
 	defer prepareCancel()
 
-	err = deps.Migrate(prepareCtx)
+	// migrate only runs the DDL, e.g. from an init container, and exits.
+	if command == "migrate" {
+		log.Println("Migrating database in progress")
+
+		err = deps.Migrate(prepareCtx)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		log.Println("Migrating database completed")
+		return
+	}
+
+	if config.SkipMigration {
+		log.Println("Skipping database migration, verifying schema")
+
+		err = deps.VerifySchema(prepareCtx)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		log.Println("Migrating database in progress")
+
+		err = deps.Migrate(prepareCtx)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		log.Println("Migrating database completed")
+	}
+
+	err = deps.LoadSettings(prepareCtx)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	log.Println("Migrating database completed")
+	if config.SeedCount > 0 {
+		err = deps.Seed(prepareCtx, config.SeedCount)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if config.Maintenance {
+		deps.SetMaintenance(true)
+	}
+
+	if config.EnablePprof {
+		log.Println("Serving runtime profiles under /debug/pprof/")
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/list", deps.List)
-	mux.HandleFunc("/api/add", deps.Add)
-	mux.HandleFunc("/", deps.Index)
+	// Without an admin listener the admin endpoints are served next to the
+	// public ones.
+	mux := deps.Routes()
+	if config.AdminAddr != "" {
+		mux = deps.PublicRoutes()
+	}
 
 	server := &http.Server{
-		Addr:    host + ":" + port,
-		Handler: mux,
+		Addr:    config.Host + ":" + config.Port,
+		Handler: deps.Handler(mux),
+	}
+
+	// Shutdown waits for active connections, long polls are released right
+	// away rather than holding it up until their wait runs out.
+	server.RegisterOnShutdown(deps.updates.close)
+
+	servers := []*http.Server{server}
+	if config.AdminAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:    config.AdminAddr,
+			Handler: deps.Handler(deps.AdminRoutes()),
+		})
+	}
+
+	// Binding up front turns a taken or privileged port into a clear error
+	// before anything else is started.
+	listeners := make([]net.Listener, len(servers))
+	for i, server := range servers {
+		listeners[i], err = listen(server.Addr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var background sync.WaitGroup
+	if deps.AggregateMode == AggregateAsync {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			deps.RunAggregator(backgroundCtx)
+		}()
+	}
+
+	if deps.AggregateMode != AggregateNone {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			deps.RunAggregatePruner(backgroundCtx, time.Minute*10)
+		}()
+	}
+
+	if deps.WebhookURL != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			deps.RunWebhooks(backgroundCtx)
+		}()
+	}
+
+	if config.BackupDir != "" {
+		log.Printf("Backing up the database to %s every %s", config.BackupDir, config.BackupInterval.String())
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			deps.RunBackups(backgroundCtx, time.Duration(config.BackupInterval))
+		}()
+	}
+
+	if config.EnableWriteBuffer {
+		log.Println("Buffering increments in memory while the database is unavailable")
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			deps.RunWriteBuffer(backgroundCtx, time.Second*5)
+		}()
 	}
 
+	// SIGTERM is what container runtimes stop with, SIGINT is ^C. SIGKILL
+	// cannot be caught at all.
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Kill, os.Interrupt)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 
-	go func() {
-		log.Printf("Server running on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("error starting server: %v", err)
+	for i, server := range servers {
+		kind := "Server"
+		if i > 0 {
+			kind = "Admin server"
 		}
-	}()
 
-	<-sig
+		go func(server *http.Server, listener net.Listener) {
+			log.Printf("%s running on %s", kind, listener.Addr())
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("error starting server: %v", err)
+			}
+		}(server, listeners[i])
+	}
+
+	received := <-sig
+	log.Printf("Received %s", received)
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*15)
+	shutdownTimeout := time.Duration(config.ShutdownTimeout)
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Println(err)
+	// Both listeners share the shutdown timeout.
+	var shutdown sync.WaitGroup
+	for _, server := range servers {
+		shutdown.Add(1)
+		go func(server *http.Server) {
+			defer shutdown.Done()
+
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Println(err)
+			}
+		}(server)
+	}
+	shutdown.Wait()
+
+	backgroundCancel()
+	background.Wait()
+
+	if config.VacuumOnShutdown {
+		vacuumCtx, vacuumCancel := context.WithTimeout(context.Background(), time.Minute*1)
+		defer vacuumCancel()
+
+		if err := compactDatabase(vacuumCtx, db, config.DatabaseURL); err != nil {
+			log.Println(err)
+		}
 	}
 }
 
+func (d *Deps) baseContext() context.Context {
+	if d.BaseContext == nil {
+		return context.Background()
+	}
+
+	return d.BaseContext
+}
+
+func (d *Deps) readDB() *sql.DB {
+	if d.ReadDB == nil {
+		return d.DB
+	}
+
+	return d.ReadDB
+}
+
+func (d *Deps) location() *time.Location {
+	if d.Location == nil {
+		return time.UTC
+	}
+
+	return d.Location
+}
+
+func (d *Deps) now() time.Time {
+	if d.Now == nil {
+		return time.Now()
+	}
+
+	return d.Now()
+}
+
 func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
+	// The mux routes every unmatched path here, only the root is the page.
+	if r.URL.Path != "/" {
+		writeProblem(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if d.IndexTemplate != nil {
+		d.renderIndexTemplate(w, r)
+		return
+	}
+
+	// The switch is only worth showing with more than one counter.
+	subjects := d.subjects()
+	subjectOptions := ""
+	for _, subject := range subjects {
+		subjectOptions += `<option value="` + html.EscapeString(subject) + `">` + html.EscapeString(subject) + `</option>`
+	}
+
+	subjectsHidden := ""
+	if len(subjects) < 2 {
+		subjectsHidden = " hidden"
+	}
+
+	// The colours come from themeCSS, the light ones are Sakura's own.
 	sakuraCss := `/* Sakura.css v1.3.1
 	* ================
 	* Minimal css theme.
@@ -108,8 +535,8 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	 line-height: 1.618;
 	 max-width: 38em;
 	 margin: auto;
-	 color: #4a4a4a;
-	 background-color: #f9f9f9;
+	 color: var(--text);
+	 background-color: var(--background);
 	 padding: 13px; }
    
    @media (max-width: 684px) {
@@ -157,16 +584,16 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	 font-size: 75%; }
    
    hr {
-	 border-color: #1d7484; }
+	 border-color: var(--accent); }
    
    a {
 	 text-decoration: none;
-	 color: #1d7484; }
+	 color: var(--accent); }
 	 a:hover {
-	   color: #982c61;
-	   border-bottom: 2px solid #4a4a4a; }
+	   color: var(--accent-hover);
+	   border-bottom: 2px solid var(--text); }
 	 a:visited {
-	   color: #144f5a; }
+	   color: var(--accent-visited); }
    
    ul {
 	 padding-left: 1.4em;
@@ -183,9 +610,9 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	 padding-top: 0.8em;
 	 padding-bottom: 0.8em;
 	 padding-right: 0.8em;
-	 border-left: 5px solid #1d7484;
+	 border-left: 5px solid var(--accent);
 	 margin-bottom: 2.5rem;
-	 background-color: #f1f1f1; }
+	 background-color: var(--background-alt); }
    
    blockquote p {
 	 margin-bottom: 0; }
@@ -198,7 +625,7 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
    
    /* Pre and Code */
    pre {
-	 background-color: #f1f1f1;
+	 background-color: var(--background-alt);
 	 display: block;
 	 padding: 1em;
 	 overflow-x: auto;
@@ -209,7 +636,7 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
    code, kbd, samp {
 	 font-size: 0.9em;
 	 padding: 0 0.5em;
-	 background-color: #f1f1f1;
+	 background-color: var(--background-alt);
 	 white-space: pre-wrap; }
    
    pre > code {
@@ -226,13 +653,13 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
    
    td, th {
 	 padding: 0.5em;
-	 border-bottom: 1px solid #f1f1f1; }
+	 border-bottom: 1px solid var(--background-alt); }
    
    /* Buttons, forms and input */
    input, textarea {
-	 border: 1px solid #4a4a4a; }
+	 border: 1px solid var(--text); }
 	 input:focus, textarea:focus {
-	   border: 1px solid #1d7484; }
+	   border: 1px solid var(--accent); }
    
    textarea {
 	 width: 100%; }
@@ -243,37 +670,37 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	 text-align: center;
 	 text-decoration: none;
 	 white-space: nowrap;
-	 background-color: #1d7484;
-	 color: #f9f9f9;
+	 background-color: var(--accent);
+	 color: var(--background);
 	 border-radius: 1px;
-	 border: 1px solid #1d7484;
+	 border: 1px solid var(--accent);
 	 cursor: pointer;
 	 box-sizing: border-box; }
 	 .button[disabled], button[disabled], input[type="submit"][disabled], input[type="reset"][disabled], input[type="button"][disabled] {
 	   cursor: default;
 	   opacity: .5; }
 	 .button:focus:enabled, .button:hover:enabled, button:focus:enabled, button:hover:enabled, input[type="submit"]:focus:enabled, input[type="submit"]:hover:enabled, input[type="reset"]:focus:enabled, input[type="reset"]:hover:enabled, input[type="button"]:focus:enabled, input[type="button"]:hover:enabled {
-	   background-color: #982c61;
-	   border-color: #982c61;
-	   color: #f9f9f9;
+	   background-color: var(--accent-hover);
+	   border-color: var(--accent-hover);
+	   color: var(--background);
 	   outline: 0; }
    
    textarea, select, input {
-	 color: #4a4a4a;
+	 color: var(--text);
 	 padding: 6px 10px;
 	 /* The 6px vertically centers text on FF, ignored by Webkit */
 	 margin-bottom: 10px;
-	 background-color: #f1f1f1;
-	 border: 1px solid #f1f1f1;
+	 background-color: var(--background-alt);
+	 border: 1px solid var(--background-alt);
 	 border-radius: 4px;
 	 box-shadow: none;
 	 box-sizing: border-box; }
 	 textarea:focus, select:focus, input:focus {
-	   border: 1px solid #1d7484;
+	   border: 1px solid var(--accent);
 	   outline: 0; }
    
    input[type="checkbox"]:focus {
-	 outline: 1px dotted #1d7484; }
+	 outline: 1px dotted var(--accent); }
    
    label, legend, fieldset {
 	 display: block;
@@ -285,7 +712,13 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	<html>
 	<head>
 	<title>How many times Raymond said sorry so far</title>
-	<style>` + sakuraCss + `</style>
+	<meta property="og:title" content="How many times Raymond said sorry so far">
+	<meta property="og:image" content="` + html.EscapeString(d.absoluteURL(r, "/og-image.png")) + `">
+	<meta property="og:image:type" content="image/png">
+	<meta property="og:image:width" content="1200">
+	<meta property="og:image:height" content="630">
+	<link rel="icon" href="` + html.EscapeString(assetURL("/favicon.ico")) + `" type="image/x-icon">
+	<style>` + d.themeCSS() + sakuraCss + `</style>
 	<style>
 		.pointer:hover {
 			cursor: pointer;
@@ -293,24 +726,63 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	</style>
 	<script>
 	async function listCounter() {
-		const response = await fetch("/api/list", { method: "GET" });
+		const response = await fetch("/api/list?name=" + encodeURIComponent(subject), { method: "GET" });
 		const respBody = await response.json();
 
 		const counterElement = document.getElementById("counter-content");
 		counterElement.innerHTML = respBody.counter;
 
 		const lastTimeElement = document.getElementById("lasttime-content");
-		if (new Date(respBody.lastDate).getUTCFullYear() == 1970) {
+		if (respBody.lastDate === null) {
 			lastTimeElement.innerHTML = "never";
 		} else {
 			lastTimeElement.innerHTML = new Date(respBody.lastDate).toLocaleString("id-ID");
 		};
+
+		if (respBody.target !== undefined) {
+			target = respBody.target;
+			showProgress(respBody.counter);
+		};
+	};
+
+	let subject = "` + html.EscapeString(subjects[0]) + `";
+
+	async function switchSubject(name) {
+		subject = name;
+		await listCounter();
+	};
+
+	// target is only known once /api/list reported one.
+	let target = 0;
+
+	function showProgress(count) {
+		if (target === 0) {
+			return;
+		};
+
+		const progressElement = document.getElementById("target-progress");
+		progressElement.max = target;
+		progressElement.value = Math.min(count, target);
+
+		const remaining = Math.max(target - count, 0);
+		document.getElementById("target-content").innerHTML = remaining === 0
+			? "The goal of " + target + " is reached"
+			: remaining + " to go until " + target;
+
+		document.getElementById("target").hidden = false;
 	};
 	
 	async function addCounter() {
-		const response = await fetch("/api/add", { method: "POST" });
-		
-		await listCounter();
+		const response = await fetch("/api/add?name=" + encodeURIComponent(subject), { method: "POST" });
+		const respBody = await response.json();
+
+		const counterElement = document.getElementById("counter-content");
+		counterElement.innerHTML = respBody.count;
+
+		const lastTimeElement = document.getElementById("lasttime-content");
+		lastTimeElement.innerHTML = new Date().toLocaleString("id-ID");
+
+		showProgress(respBody.count);
 	};
 
 	setInterval(async () => {
@@ -323,11 +795,19 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 		How many times Raymond said sorry, so far
 	</h4>
 
+	<div style="text-align: center;"` + subjectsHidden + `>
+		<select id="subject" onchange="switchSubject(this.value)">` + subjectOptions + `</select>
+	</div>
+
 	<h1 style="font-size: 8rem; margin-top: 2rem; text-align: center; margin-left: auto; margin-right: auto;">
 	  <span id="counter-content">0</span>
 	</h1>
 
 	<p style="text-align: center;">Last time he said it, it was at <span id="lasttime-content">never</span></p>
+	<div id="target" style="text-align: center;" hidden>
+		<progress id="target-progress" style="width: 100%;"></progress>
+		<p id="target-content"></p>
+	</div>
 	<div onclick="addCounter()" class="pointer">
 		<h3 style="margin-top: 0.5rem; text-align: center;">He said it again!</h3>
 	</div>
@@ -335,7 +815,12 @@ func (d *Deps) Index(w http.ResponseWriter, r *http.Request) {
 	</html>`
 
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Length", strconv.Itoa(len(htmlResponse)))
 	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
 	w.Write([]byte(htmlResponse))
 }
 
@@ -350,7 +835,7 @@ func (d *Deps) Migrate(ctx context.Context) error {
 		}
 	}()
 
-	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: false})
+	tx, err := c.BeginTx(ctx, d.txOptions())
 	if err != nil {
 		return err
 	}
@@ -370,13 +855,29 @@ func (d *Deps) Migrate(ctx context.Context) error {
 		return err
 	}
 
-	_, err = tx.ExecContext(
-		ctx,
-		`CREATE TABLE IF NOT EXISTS counter_aggregate (
-			counts INTEGER NOT NULL,
-			created_at DATETIME NOT NULL
-		)`,
-	)
+	// Without aggregates the table is not needed, an existing one is left
+	// alone in case the mode is switched back.
+	tables := []string{"counter"}
+	if d.AggregateMode != AggregateNone {
+		tables = append(tables, "counter_aggregate")
+
+		_, err = tx.ExecContext(
+			ctx,
+			`CREATE TABLE IF NOT EXISTS counter_aggregate (
+				counts INTEGER NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+		)
+		if err != nil {
+			if e := tx.Rollback(); e != nil {
+				return e
+			}
+
+			return err
+		}
+	}
+
+	err = addColumn(ctx, tx, "counter", "reason", "TEXT")
 	if err != nil {
 		if e := tx.Rollback(); e != nil {
 			return e
@@ -385,216 +886,1093 @@ func (d *Deps) Migrate(ctx context.Context) error {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
+	err = addColumn(ctx, tx, "counter", "deleted_at", "DATETIME")
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
 		return err
 	}
 
-	return nil
-}
+	// Rows from before counters had names belong to the default one.
+	for _, table := range tables {
+		err = addColumn(ctx, tx, table, "name", "TEXT NOT NULL DEFAULT '"+defaultCounterName+"'")
+		if err != nil {
+			if e := tx.Rollback(); e != nil {
+				return e
+			}
 
-func (d *Deps) Add(w http.ResponseWriter, r *http.Request) {
-	conn, err := d.DB.Conn(r.Context())
+			return err
+		}
+	}
+
+	err = addCounterID(ctx, tx)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
 	}
-	defer func() {
-		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
-			log.Println(err)
+
+	// Added after the id rebuild, which only carries the older columns over.
+	err = addColumn(ctx, tx, "counter", "visitor_id", "TEXT")
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
 		}
-	}()
 
-	tx, err := conn.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: false})
+		return err
+	}
+
+	err = addAdjustmentColumn(ctx, tx)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
 	}
 
-	_, err = tx.ExecContext(
-		r.Context(),
-		`INSERT INTO counter (count, created_at) VALUES (?, ?)`,
-		1,
-		time.Now(),
-	)
+	// Every sum over a counter filters on these, which matters most with
+	// AggregateNone where List sums on every read.
+	_, err = tx.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS counter_name ON counter (name, deleted_at)`)
 	if err != nil {
 		if e := tx.Rollback(); e != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-			return
+			return e
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
+	_, err = tx.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS milestones (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '`+defaultCounterName+`',
+			count INTEGER NOT NULL,
+			note TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+	)
+	if err != nil {
 		if e := tx.Rollback(); e != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-			return
+			return e
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+		return err
 	}
 
-	go d.CreateAggregate()
+	// Tables holding timestamps cannot be STRICT, the driver only scans a
+	// time.Time from a column declared DATETIME, which STRICT does not allow.
+	strict := ""
+	if d.StrictTables {
+		ok, err := supportsStrictTables(ctx, tx)
+		if err != nil {
+			if e := tx.Rollback(); e != nil {
+				return e
+			}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message":"success"}`))
-}
+			return err
+		}
 
-func (d *Deps) List(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
-	defer cancel()
+		if ok {
+			strict = " STRICT"
+		} else {
+			log.Println("Creating tables without STRICT, the SQLite library is older than 3.37.0")
+		}
+	}
 
-	c, err := d.DB.Conn(ctx)
+	// Foreign keys are only enforced with SQLITE_FOREIGN_KEYS, deleteEvent
+	// cleans up after itself either way.
+	_, err = tx.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS redo_stack (
+			id INTEGER PRIMARY KEY,
+			event_id INTEGER NOT NULL REFERENCES counter(id) ON DELETE CASCADE,
+			name TEXT NOT NULL
+		)`+strict,
+	)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
-	}
-	defer func() {
-		if err := c.Close(); err != nil {
-			log.Println(err)
+		if e := tx.Rollback(); e != nil {
+			return e
 		}
-	}()
 
-	var counts int
-	var lastDate time.Time
-	err = c.QueryRowContext(
+		return err
+	}
+
+	_, err = tx.ExecContext(
 		ctx,
-		`SELECT counts, created_at FROM counter_aggregate ORDER BY created_at DESC LIMIT 1`,
-	).Scan(
-		&counts,
-		&lastDate,
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`+strict,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			responseBody, err := json.Marshal(map[string]interface{}{
-				"counter":  0,
-				"lastDate": time.Unix(0, 0).Format(time.RFC3339),
-			})
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(responseBody)
-			return
+		if e := tx.Rollback(); e != nil {
+			return e
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+		return err
 	}
 
-	responseBody, err := json.Marshal(map[string]interface{}{
-		"counter":  counts,
-		"lastDate": lastDate.Format(time.RFC3339),
-	})
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":` + strconv.Quote(err.Error()) + `}`))
-		return
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	return nil
 }
 
-func (d *Deps) CreateAggregate() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-
+// VerifySchema checks that every table Migrate would create already exists.
+// It is used in place of Migrate when the schema is managed outside the app.
+// With AggregateNone the aggregate table is not required.
+func (d *Deps) VerifySchema(ctx context.Context) error {
 	c, err := d.DB.Conn(ctx)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	defer func() {
-		err := c.Close()
-		if err != nil {
+		if err := c.Close(); err != nil {
 			log.Println(err)
 		}
 	}()
 
-	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: false})
-	if err != nil {
-		log.Println(err)
-		return
+	tables := []string{"counter", "milestones", "redo_stack", "settings"}
+	if d.AggregateMode != AggregateNone {
+		tables = append(tables, "counter_aggregate")
 	}
 
-	rows, err := tx.QueryContext(
+	for _, table := range tables {
+		var name string
+		err := c.QueryRowContext(
+			ctx,
+			`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`,
+			table,
+		).Scan(&name)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("table %q does not exist, run the migration first", table)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddResponse is the JSON body Add answers with.
+type AddResponse struct {
+	// Message is "success", "dry-run", or "queued" when the write failed and
+	// the increment waits in the write buffer.
+	Message string `json:"message"`
+	// Count is the new total, nil when the increment was queued.
+	Count *int `json:"count,omitempty"`
+	// Added tells the client what its request was worth, which is not
+	// obvious when it relied on DefaultWeight.
+	Added int `json:"added"`
+}
+
+func (d *Deps) Add(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "dryRun must be a boolean")
+			return
+		}
+	}
+
+	if d.IsPaused() {
+		writeProblem(w, http.StatusConflict, "counting paused")
+		return
+	}
+
+	input, fieldErrors, err := d.decodeAddRequest(r)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if fieldErrors != nil {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if dryRun {
+		d.dryRunAdd(w, r, input)
+		return
+	}
+
+	var counts int
+	err = d.retryTx(r.Context(), func() error {
+		var err error
+		counts, err = d.insertEvent(r.Context(), input)
+		return err
+	})
+	if err != nil {
+		var cooldown cooldownError
+		if errors.As(err, &cooldown) {
+			// Retry-After only takes whole seconds, round up so a client
+			// that honours it is not turned away again.
+			retryAfter := int((cooldown.wait + time.Second - 1) / time.Second)
+
+			p := newProblem(http.StatusTooManyRequests, err.Error())
+			p.RetryAfter = retryAfter
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			p.write(w)
+			return
+		}
+
+		if d.EnableWriteBuffer {
+			d.bufferAdd(w, r, input, err)
+			return
+		}
+
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.refreshAggregate(r.Context(), input.Name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "the increment was saved but aggregating failed: "+err.Error())
+		return
+	}
+
+	if d.WebhookURL != "" {
+		d.NotifyWebhook(input.Name, input.Subject, counts, input.CreatedAt)
+	}
+
+	if d.MilestoneEvery > 0 {
+		d.autoMilestone(r.Context(), input.Name, counts-input.Amount, counts)
+	}
+
+	if d.Hooks.OnAdd != nil {
+		go d.Hooks.OnAdd(d.baseContext(), input.Amount, counts)
+	}
+
+	d.writeJSON(w, r, http.StatusOK, AddResponse{
+		Message: "success",
+		Count:   &counts,
+		Added:   input.Amount,
+	})
+}
+
+// dryRunAdd answers a validated Add request with the total it would lead to,
+// without writing anything, so nothing gets aggregated or notified either.
+func (d *Deps) dryRunAdd(w http.ResponseWriter, r *http.Request, input addInput) {
+	counts, err := liveTotal(r.Context(), d.DB, input.Name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	counts += input.Amount
+	d.writeJSON(w, r, http.StatusOK, AddResponse{
+		Message: "dry-run",
+		Count:   &counts,
+		Added:   input.Amount,
+	})
+}
+
+// insertEvent records an increment and returns the new total of its counter.
+func (d *Deps) insertEvent(ctx context.Context, input addInput) (int, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, err
+	}
+
+	if d.AddCooldown > 0 {
+		// Checked within the transaction so two concurrent Adds cannot both
+		// slip through.
+		if err := d.checkCooldown(ctx, tx, input.Name); err != nil {
+			if e := tx.Rollback(); e != nil {
+				return 0, e
+			}
+
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(
 		ctx,
-		`SELECT count FROM counter`,
+		`INSERT INTO counter (name, count, created_at, reason, visitor_id) VALUES (?, ?, ?, ?, ?)`,
+		input.Name,
+		input.Amount,
+		input.CreatedAt,
+		sql.NullString{String: input.Reason, Valid: input.Reason != ""},
+		sql.NullString{String: input.VisitorID, Valid: input.VisitorID != ""},
 	)
 	if err != nil {
-		log.Println(err)
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	// A fresh increment starts a new history, what was undone stays undone.
+	_, err = tx.ExecContext(ctx, `DELETE FROM redo_stack WHERE name = ?`, input.Name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	// The aggregate may well be recomputed asynchronously, so the fresh total
+	// is read within the same transaction to give the client an accurate
+	// number.
+	counts, err := liveTotal(ctx, tx, input.Name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return counts, nil
+}
+
+// cooldownError rejects an Add that came within AddCooldown of the previous
+// increment of the counter.
+type cooldownError struct {
+	wait time.Duration
+}
+
+func (e cooldownError) Error() string {
+	return "the counter was incremented too recently, try again in " + e.wait.Round(time.Second).String()
+}
+
+// checkCooldown returns a cooldownError when the latest increment of the
+// counter is less than AddCooldown ago.
+func (d *Deps) checkCooldown(ctx context.Context, tx *sql.Tx, name string) error {
+	var latest time.Time
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT created_at FROM counter WHERE name = ? AND deleted_at IS NULL ORDER BY julianday(created_at) DESC LIMIT 1`,
+		name,
+	).Scan(&latest)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	if wait := d.AddCooldown - d.now().Sub(latest); wait > 0 {
+		return cooldownError{wait: wait}
+	}
+
+	return nil
+}
+
+// Subtract takes back the latest increment. The event is soft-deleted by
+// setting deleted_at rather than removed, so the history stays auditable.
+func (d *Deps) Subtract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var counts int
+	err = d.retryTx(r.Context(), func() error {
+		var err error
+		counts, err = d.deleteLatestEvent(r.Context(), name)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errNothingToSubtract) {
+			writeProblem(w, http.StatusNotFound, "there is nothing to subtract")
+			return
+		}
+
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.refreshAggregate(r.Context(), name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "the event was subtracted but aggregating failed: "+err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message": "success",
+		"count":   counts,
+	})
+}
+
+var errNothingToSubtract = errors.New("there is nothing to subtract")
+
+// deleteLatestEvent soft-deletes the latest live event of the named counter
+// and returns its new total, or errNothingToSubtract when there is none.
+func (d *Deps) deleteLatestEvent(ctx context.Context, name string) (int, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE counter SET deleted_at = ? WHERE rowid = (
+			SELECT rowid FROM counter
+			WHERE name = ? AND deleted_at IS NULL
+			ORDER BY julianday(created_at) DESC, rowid DESC
+			LIMIT 1
+		)`,
+		d.now(),
+		name,
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil || deleted == 0 {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, errNothingToSubtract
+	}
+
+	counts, err := liveTotal(ctx, tx, name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return counts, nil
+}
+
+// ListResponse is the JSON body List answers with.
+type ListResponse struct {
+	Counter int `json:"counter"`
+	// LastDate is nil for a counter that was never aggregated.
+	LastDate *string `json:"lastDate"`
+	// Target, Remaining and PercentOfTarget are only set with a TARGET.
+	Target          *int     `json:"target,omitempty"`
+	Remaining       *int     `json:"remaining,omitempty"`
+	PercentOfTarget *float64 `json:"percentOfTarget,omitempty"`
+	// Trend is only set when a ?period= was asked for.
+	Trend *trend `json:"trend,omitempty"`
+}
+
+// List returns the latest aggregate of the counter picked by ?name=. With ?wait=N it long-polls: the request
+// is held for up to N seconds until the count differs from ?since. The ETag
+// changes with every aggregate, HEAD requests only get the headers. Browsers
+// asking for text/html get a small HTML widget instead of JSON. With
+// ?period=day, week or month the JSON also carries a trend of the events in
+// the current period against the previous one.
+func (d *Deps) List(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	wait, since, err := parseLongPoll(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period != "" {
+		if _, _, err := periodBounds(d.now(), period); err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15+wait)
+	defer cancel()
+
+	changed := d.updates.wait()
+
+	counts, lastDate, err := d.latestAggregate(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		if since < 0 {
+			since = counts
+		}
+
+		timeout := time.NewTimer(wait)
+		defer timeout.Stop()
+
+		shutdown := d.updates.closed()
+
+	poll:
+		for counts == since {
+			select {
+			case <-changed:
+			case <-timeout.C:
+				break poll
+			case <-shutdown:
+				// The client gets the current value and polls again, ideally
+				// reaching an instance that is not going away.
+				w.Header().Set("Connection", "close")
+				break poll
+			case <-r.Context().Done():
+				return
+			}
+
+			changed = d.updates.wait()
+
+			counts, lastDate, err = d.latestAggregate(ctx, name)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	}
+
+	contentType := "application/json"
+	// UnixNano is undefined for the zero time of a never aggregated counter.
+	var lastNano int64
+	if !lastDate.IsZero() {
+		lastNano = lastDate.UnixNano()
+	}
+	etag := strconv.Itoa(counts) + "-" + strconv.FormatInt(lastNano, 36)
+
+	var responseBody []byte
+	if prefersHTML(r) {
+		contentType = "text/html; charset=utf-8"
+		etag += "-html"
+		responseBody = listHTML(name, counts, lastDate)
+	} else {
+		if wantsPretty(r) {
+			etag += "-pretty"
+		}
+
+		body := ListResponse{Counter: counts}
+		if !lastDate.IsZero() {
+			last := lastDate.Format(time.RFC3339)
+			body.LastDate = &last
+		}
+
+		if d.Target > 0 {
+			target := d.Target
+			remaining, percent := targetProgress(counts, target)
+			body.Target = &target
+			body.Remaining = &remaining
+			body.PercentOfTarget = &percent
+			etag += "-t" + strconv.Itoa(d.Target)
+		}
+
+		// The trend moves with the clock as well as with the count, so it
+		// is part of the ETag.
+		if period != "" {
+			trend, err := d.periodTrend(ctx, name, period)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			body.Trend = &trend
+			etag += "-" + period + "-" + strconv.Itoa(trend.Current) + "-" + strconv.Itoa(trend.Previous)
+		}
+
+		responseBody, err = d.marshalJSON(r, body)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	etag = `"` + etag + `"`
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseBody)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
 		return
 	}
+
+	w.Write(responseBody)
+}
+
+// targetProgress returns how far counts is from target and how much of it was
+// reached in percent, rounded to one decimal. Going past the target leaves
+// nothing remaining and more than 100 percent.
+func targetProgress(counts, target int) (int, float64) {
+	remaining := target - counts
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, math.Round(float64(counts)*1000/float64(target)) / 10
+}
+
+// maxLongPollWait caps how long List holds on to a long-polling request.
+const maxLongPollWait = time.Minute
+
+// parseLongPoll reads the optional wait and since query parameters of List.
+// since is -1 when absent, meaning any change from the current count.
+func parseLongPoll(r *http.Request) (time.Duration, int, error) {
+	query := r.URL.Query()
+
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxLongPollWait {
+			return 0, 0, fmt.Errorf("wait must be a number of seconds between 0 and %d", int(maxLongPollWait.Seconds()))
+		}
+
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	since := -1
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("since must be a non-negative count")
+		}
+
+		since = n
+	}
+
+	return wait, since, nil
+}
+
+// liveAggregateQuery sums the live events of a counter and dates the total by
+// the latest of them. created_at has to be a plain column to keep its
+// DATETIME type, so the sum is taken in a subquery.
+const liveAggregateQuery = `SELECT
+		(SELECT COALESCE(SUM(count), 0) FROM counter WHERE name = ?1 AND deleted_at IS NULL),
+		created_at
+	FROM counter
+	WHERE name = ?1 AND deleted_at IS NULL
+	ORDER BY julianday(created_at) DESC
+	LIMIT 1`
+
+// latestAggregate returns the newest aggregated count and when it was
+// computed, served from the list cache while it is fresh. A counter that was
+// never aggregated is summed from the counter table instead, as it always is
+// with AggregateNone. One without events is zero at the zero time.
+func (d *Deps) latestAggregate(ctx context.Context, name string) (int, time.Time, error) {
+	if counts, lastDate, ok := d.listCache.get(name); ok {
+		return counts, lastDate, nil
+	}
+
+	generation := d.listCache.generation()
+
+	c, err := d.readDB().Conn(ctx)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
 	defer func() {
-		err := rows.Close()
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	query := `SELECT counts, created_at FROM counter_aggregate WHERE name = ? ORDER BY created_at DESC LIMIT 1`
+	if d.AggregateMode == AggregateNone {
+		query = liveAggregateQuery
+	}
+
+	var counts int
+	var lastDate time.Time
+	err = c.QueryRowContext(ctx, query, name).Scan(&counts, &lastDate)
+	if errors.Is(err, sql.ErrNoRows) && query != liveAggregateQuery {
+		// The aggregator may not have caught up with the first events of
+		// the counter, or died before it did. Rather than reporting zero,
+		// sum them here.
+		err = c.QueryRowContext(ctx, liveAggregateQuery, name).Scan(&counts, &lastDate)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, err
+		}
+
+		counts = 0
+		lastDate = time.Time{}
+	}
+
+	d.listCache.set(generation, name, counts, lastDate, d.ListCacheTTL)
+
+	return counts, lastDate, nil
+}
+
+// Aggregate modes, see Deps.AggregateMode.
+const (
+	AggregateAsync = "async"
+	AggregateSync  = "sync"
+	AggregateNone  = "none"
+)
+
+// refreshAggregate brings the aggregate of the named counter up to date after
+// a write, the way AggregateMode asks for. In AggregateAsync it only fails
+// with ReadYourWrites, when the aggregate it waited for could not be created.
+func (d *Deps) refreshAggregate(ctx context.Context, name string) error {
+	if d.AggregateMode == AggregateSync || d.AggregateMode == AggregateNone {
+		return d.CreateAggregate(ctx, name)
+	}
+
+	if !d.ReadYourWrites {
+		d.ScheduleAggregate(name)
+		return nil
+	}
+
+	done := make(chan error, 1)
+	d.scheduleAggregate(name, done)
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ScheduleAggregate asks RunAggregator to recompute the aggregate of the
+// named counter. It never blocks: a request that is already pending covers
+// this one as well.
+func (d *Deps) ScheduleAggregate(name string) {
+	d.scheduleAggregate(name, nil)
+}
+
+// scheduleAggregate is ScheduleAggregate that, unless done is nil, gets the
+// outcome of the run covering this request sent to done. The run starts
+// after the call, so it sees every write committed before.
+func (d *Deps) scheduleAggregate(name string, done chan error) {
+	d.aggregateMu.Lock()
+	if d.aggregatePending == nil {
+		d.aggregatePending = make(map[string][]chan error)
+	}
+	waiters := d.aggregatePending[name]
+	if done != nil {
+		waiters = append(waiters, done)
+	}
+	d.aggregatePending[name] = waiters
+	d.aggregateMu.Unlock()
+
+	select {
+	case d.aggregateSignal() <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Deps) aggregateSignal() chan struct{} {
+	d.aggregateOnce.Do(func() {
+		d.aggregateSignals = make(chan struct{}, 1)
+	})
+
+	return d.aggregateSignals
+}
+
+// RunAggregator is the single worker behind ScheduleAggregate. It waits until
+// no other request arrived within AggregateDebounce, so a burst of increments
+// results in a single CreateAggregate run that sees all of them. It returns
+// once ctx is done.
+func (d *Deps) RunAggregator(ctx context.Context) {
+	signals := d.aggregateSignal()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		timer := time.NewTimer(d.AggregateDebounce)
+	debounce:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-signals:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(d.AggregateDebounce)
+			case <-timer.C:
+				break debounce
+			}
+		}
+
+		d.aggregateMu.Lock()
+		pending := d.aggregatePending
+		d.aggregatePending = nil
+		d.aggregateMu.Unlock()
+
+		for name, waiters := range pending {
+			aggregateCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+			err := d.CreateAggregate(aggregateCtx, name)
+			if err != nil {
+				log.Printf("creating aggregate of %s: %v", name, err)
+			}
+			cancel()
+
+			// Every waiter has room for its outcome, this never blocks.
+			for _, done := range waiters {
+				done <- err
+			}
+		}
+	}
+}
+
+// CreateAggregate sums the rows of the named counter and stores the result as
+// its newest counter_aggregate row, which is what List reads from. With
+// AggregateNone nothing is stored, List reads the counter table and only has
+// to be told about the change.
+func (d *Deps) CreateAggregate(ctx context.Context, name string) error {
+	if d.AggregateMode == AggregateNone {
+		d.listCache.invalidate()
+		d.updates.publish()
+		return nil
+	}
+
+	var counts int
+	err := d.retryTx(ctx, func() error {
+		var err error
+		counts, err = d.insertAggregate(ctx, name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	d.listCache.invalidate()
+	d.updates.publish()
+
+	log.Printf("Aggregate of %s created, with counts: %d", name, counts)
+
+	return nil
+}
+
+func (d *Deps) insertAggregate(ctx context.Context, name string) (int, error) {
+	c, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
 		if err != nil {
 			log.Println(err)
 		}
 	}()
 
+	tx, err := c.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT count FROM counter WHERE name = ? AND deleted_at IS NULL`,
+		name,
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
 	var counts int
 	for rows.Next() {
 		var count int
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Println(err)
-			return
+			if e := rows.Close(); e != nil {
+				log.Println(e)
+			}
+
+			if e := tx.Rollback(); e != nil {
+				return 0, e
+			}
+
+			return 0, err
 		}
 
 		counts += count
 	}
 
+	if err := rows.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if err := rows.Err(); err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, e
+		}
+
+		return 0, err
+	}
+
 	_, err = tx.ExecContext(
 		ctx,
 		`INSERT INTO
 			counter_aggregate
-			(counts, created_at)
+			(name, counts, created_at)
 			VALUES
-			(?, ?)`,
+			(?, ?, ?)`,
+		name,
 		counts,
-		time.Now(),
+		d.now(),
 	)
 	if err != nil {
 		if e := tx.Rollback(); e != nil {
-			log.Println(err)
-			return
+			return 0, e
 		}
 
-		log.Println(err)
-		return
+		return 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Println(err)
+		return 0, err
+	}
+
+	return counts, nil
+}
+
+// Recompute synchronously recomputes the aggregate, reporting any failure back
+// to the caller.
+func (d *Deps) Recompute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	err = d.CreateAggregate(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("Aggregate created, with counts: %d", counts)
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{"message": "success"})
+}
+
+// PruneAggregates deletes every counter_aggregate row except the latest
+// AggregateKeep ones of each counter. Only the latest row is ever read, the
+// rest are kept around for debugging.
+func (d *Deps) PruneAggregates(ctx context.Context) error {
+	result, err := d.DB.ExecContext(
+		ctx,
+		`DELETE FROM counter_aggregate
+			WHERE rowid NOT IN (
+				SELECT rowid FROM (
+					SELECT rowid, ROW_NUMBER() OVER (PARTITION BY name ORDER BY created_at DESC) AS position
+					FROM counter_aggregate
+				)
+				WHERE position <= ?
+			)`,
+		d.AggregateKeep,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		log.Printf("Pruned %d aggregate rows", deleted)
+	}
+
+	return nil
+}
+
+// RunAggregatePruner calls PruneAggregates on every interval until ctx is done.
+func (d *Deps) RunAggregatePruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruneCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+			if err := d.PruneAggregates(pruneCtx); err != nil {
+				log.Println(err)
+			}
+			cancel()
+		}
+	}
 }
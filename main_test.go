@@ -0,0 +1,1796 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDeps opens a fresh database and runs the migration against it.
+// By default it uses a SQLite file inside a temporary directory, which behaves
+// like the production setup while still being thrown away after the test.
+// Set TEST_DATABASE_DRIVER and TEST_DATABASE_URL to point the suite at another
+// database instead.
+func newTestDeps(t testing.TB) *Deps {
+	t.Helper()
+
+	driver, ok := os.LookupEnv("TEST_DATABASE_DRIVER")
+	if !ok {
+		driver = "sqlite3"
+	}
+
+	dsn, ok := os.LookupEnv("TEST_DATABASE_URL")
+	if !ok {
+		dsn = filepath.Join(t.TempDir(), "db.sqlite")
+	}
+
+	// Tests run with foreign keys enforced like the server does by default.
+	if driver == "sqlite3" {
+		dsn = sqliteDSN(dsn, true)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	// No aggregation worker runs during tests, they call CreateAggregate
+	// themselves when they need it.
+	deps := &Deps{DB: db, AggregateDebounce: time.Hour}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("closing database: %v", err)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if err := deps.Migrate(ctx); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	return deps
+}
+
+// newTestServer starts the full server, with the real routes and middleware,
+// on a random port against a fresh in-memory database. It returns the base
+// URL and a cleanup func that shuts everything down again. The aggregation
+// worker runs without a debounce, so the aggregate catches up right after
+// every write.
+func newTestServer(t testing.TB) (string, func()) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+url.PathEscape(t.Name())+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	config := defaultConfig()
+	ctx, cancel := context.WithCancel(context.Background())
+	deps := &Deps{
+		DB:                    db,
+		BaseContext:           ctx,
+		AggregateKeep:         config.AggregateKeep,
+		MaxBodyBytes:          config.MaxBodyBytes,
+		MaxImportBytes:        config.MaxImportBytes,
+		TxMaxAttempts:         config.TxMaxAttempts,
+		ContentSecurityPolicy: config.ContentSecurityPolicy,
+	}
+
+	if err := deps.Migrate(ctx); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deps.RunAggregator(ctx)
+	}()
+
+	server := httptest.NewServer(deps.Handler(deps.Routes()))
+
+	return server.URL, func() {
+		server.Close()
+		cancel()
+		<-done
+
+		if err := db.Close(); err != nil {
+			t.Errorf("closing database: %v", err)
+		}
+	}
+}
+
+func doAdd(t testing.TB, deps *Deps) map[string]interface{} {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("add: decoding response body: %v", err)
+	}
+
+	return body
+}
+
+func doList(t testing.TB, deps *Deps) map[string]interface{} {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("list: decoding response body: %v", err)
+	}
+
+	return body
+}
+
+func createAggregate(t testing.TB, deps *Deps) {
+	t.Helper()
+
+	if err := deps.CreateAggregate(context.Background(), defaultCounterName); err != nil {
+		t.Fatalf("creating aggregate: %v", err)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	deps := newTestDeps(t)
+
+	if err := deps.Migrate(context.Background()); err != nil {
+		t.Fatalf("second migration: %v", err)
+	}
+
+	if err := deps.VerifySchema(context.Background()); err != nil {
+		t.Fatalf("verifying schema: %v", err)
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	deps := newTestDeps(t)
+
+	body := doList(t, deps)
+
+	if body["counter"] != float64(0) {
+		t.Errorf("expected counter to be 0, got %v", body["counter"])
+	}
+
+	if lastDate, ok := body["lastDate"]; !ok || lastDate != nil {
+		t.Errorf("expected lastDate to be null, got %v", lastDate)
+	}
+}
+
+func TestListFieldOrder(t *testing.T) {
+	deps := newTestDeps(t)
+
+	rec := httptest.NewRecorder()
+	deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+
+	if got, want := rec.Body.String(), `{"counter":0,"lastDate":null}`; got != want {
+		t.Errorf("expected body %s, got %s", want, got)
+	}
+}
+
+func TestListTarget(t *testing.T) {
+	deps := newTestDeps(t)
+
+	body := doList(t, deps)
+	for _, key := range []string{"target", "remaining", "percentOfTarget"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("expected no %s without a target, got %v", key, body[key])
+		}
+	}
+
+	deps.Target = 3
+	doAdd(t, deps)
+
+	body = doList(t, deps)
+	if body["target"] != float64(3) || body["remaining"] != float64(2) || body["percentOfTarget"] != 33.3 {
+		t.Errorf("expected 2 remaining at 33.3%% of 3, got %v", body)
+	}
+
+	for i := 0; i < 3; i++ {
+		doAdd(t, deps)
+	}
+
+	body = doList(t, deps)
+	if body["remaining"] != float64(0) || body["percentOfTarget"] != 133.3 {
+		t.Errorf("expected nothing remaining at 133.3%%, got %v", body)
+	}
+}
+
+func TestAddIncrementsCount(t *testing.T) {
+	deps := newTestDeps(t)
+
+	for i := 1; i <= 3; i++ {
+		body := doAdd(t, deps)
+
+		if body["message"] != "success" {
+			t.Errorf("expected message to be success, got %v", body["message"])
+		}
+
+		if body["count"] != float64(i) {
+			t.Errorf("expected count to be %d, got %v", i, body["count"])
+		}
+	}
+}
+
+func TestCreateAggregate(t *testing.T) {
+	deps := newTestDeps(t)
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+
+	// Without any aggregate List falls back to summing the events.
+	if body := doList(t, deps); body["counter"] != float64(2) {
+		t.Errorf("expected counter to be 2 before aggregating, got %v", body["counter"])
+	}
+
+	createAggregate(t, deps)
+	doAdd(t, deps)
+
+	// Once there is one, the aggregate is what List reads, even when it lags.
+	body := doList(t, deps)
+	if body["counter"] != float64(2) {
+		t.Errorf("expected counter to be 2, got %v", body["counter"])
+	}
+
+	lastDate, err := time.Parse(time.RFC3339, body["lastDate"].(string))
+	if err != nil {
+		t.Fatalf("parsing lastDate: %v", err)
+	}
+
+	if time.Since(lastDate) > time.Minute {
+		t.Errorf("expected lastDate to be recent, got %s", lastDate)
+	}
+}
+
+func TestAggregateModes(t *testing.T) {
+	for _, mode := range []string{AggregateSync, AggregateNone} {
+		t.Run(mode, func(t *testing.T) {
+			deps := newTestDeps(t)
+			deps.AggregateMode = mode
+
+			doAdd(t, deps)
+			doAdd(t, deps)
+
+			// Nothing runs the aggregator, the total has to be there as soon
+			// as Add responded.
+			body := doList(t, deps)
+			if body["counter"] != float64(2) {
+				t.Errorf("expected counter to be 2, got %v", body["counter"])
+			}
+
+			if body["lastDate"] == nil {
+				t.Error("expected lastDate to be set")
+			}
+
+			var rows int
+			err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter_aggregate`).Scan(&rows)
+			if err != nil {
+				t.Fatalf("counting aggregates: %v", err)
+			}
+
+			if mode == AggregateNone && rows != 0 {
+				t.Errorf("expected no aggregate rows, got %d", rows)
+			}
+
+			if mode == AggregateSync && rows != 2 {
+				t.Errorf("expected an aggregate row per increment, got %d", rows)
+			}
+		})
+	}
+}
+
+func TestMigrateWithoutAggregate(t *testing.T) {
+	db, err := sql.Open("sqlite3", sqliteDSN(filepath.Join(t.TempDir(), "db.sqlite"), true))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	deps := &Deps{DB: db, AggregateMode: AggregateNone}
+	if err := deps.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	var tables int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'counter_aggregate'`).Scan(&tables)
+	if err != nil {
+		t.Fatalf("looking up the aggregate table: %v", err)
+	}
+
+	if tables != 0 {
+		t.Error("expected no aggregate table")
+	}
+
+	if err := deps.VerifySchema(context.Background()); err != nil {
+		t.Errorf("expected the schema to verify without the aggregate table: %v", err)
+	}
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+
+	if body := doList(t, deps); body["counter"] != float64(2) {
+		t.Errorf("expected counter to be 2, got %v", body["counter"])
+	}
+
+	deps.AggregateMode = AggregateAsync
+	if err := deps.VerifySchema(context.Background()); err == nil {
+		t.Error("expected the aggregate table to be required again")
+	}
+}
+
+// seedCounter inserts n single increments spread over the past n minutes.
+func seedCounter(t testing.TB, deps *Deps, n int) {
+	t.Helper()
+
+	tx, err := deps.DB.Begin()
+	if err != nil {
+		t.Fatalf("beginning transaction: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO counter (count, created_at) VALUES (?, ?)`)
+	if err != nil {
+		t.Fatalf("preparing statement: %v", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		if _, err := stmt.Exec(1, now.Add(-time.Minute*time.Duration(n-i))); err != nil {
+			t.Fatalf("seeding counter: %v", err)
+		}
+	}
+
+	if err := stmt.Close(); err != nil {
+		t.Fatalf("closing statement: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("committing transaction: %v", err)
+	}
+}
+
+// silenceLog discards the standard logger output for the rest of the test,
+// CreateAggregate logs on every run which drowns the benchmark output.
+func silenceLog(t testing.TB) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+	})
+}
+
+func BenchmarkCreateAggregate(b *testing.B) {
+	for _, n := range []int{100, 1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			deps := newTestDeps(b)
+			seedCounter(b, deps, n)
+			silenceLog(b)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				createAggregate(b, deps)
+			}
+		})
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	deps := newTestDeps(b)
+	seedCounter(b, deps, 1_000)
+	silenceLog(b)
+	createAggregate(b, deps)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/list", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		deps.List(rec, req)
+
+		if rec.Code != http.StatusOK {
+			b.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+}
+
+func TestClockIsUsedForTimestamps(t *testing.T) {
+	deps := newTestDeps(t)
+
+	fixed := time.Date(2022, time.July, 19, 10, 30, 0, 0, time.UTC)
+	deps.Now = func() time.Time {
+		return fixed
+	}
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	var createdAt time.Time
+	if err := deps.DB.QueryRow(`SELECT created_at FROM counter`).Scan(&createdAt); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if !createdAt.Equal(fixed) {
+		t.Errorf("expected counter created_at to be %s, got %s", fixed, createdAt)
+	}
+
+	body := doList(t, deps)
+	if body["lastDate"] != fixed.Format(time.RFC3339) {
+		t.Errorf("expected lastDate to be %s, got %v", fixed.Format(time.RFC3339), body["lastDate"])
+	}
+}
+
+func TestAddBackfill(t *testing.T) {
+	deps := newTestDeps(t)
+
+	now := time.Date(2022, time.July, 19, 10, 30, 0, 0, time.UTC)
+	deps.Now = func() time.Time {
+		return now
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "past timestamp", body: `{"at":"2022-01-31T08:00:00+07:00"}`, wantStatus: http.StatusOK},
+		{name: "future timestamp", body: `{"at":"2022-07-20T00:00:00Z"}`, wantStatus: http.StatusBadRequest},
+		{name: "unparseable timestamp", body: `{"at":"yesterday"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed body", body: `{"at":`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	var count int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&count); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("expected exactly one backfilled row, got %d", count)
+	}
+
+	var createdAt time.Time
+	if err := deps.DB.QueryRow(`SELECT created_at FROM counter`).Scan(&createdAt); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	want := time.Date(2022, time.January, 31, 1, 0, 0, 0, time.UTC)
+	if !createdAt.Equal(want) {
+		t.Errorf("expected created_at to be %s, got %s", want, createdAt)
+	}
+}
+
+func TestRunAggregatorCoalescesBursts(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.AggregateDebounce = time.Millisecond * 100
+	silenceLog(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deps.RunAggregator(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for i := 0; i < 5; i++ {
+		doAdd(t, deps)
+	}
+
+	// List would sum the events without an aggregate, so wait for the
+	// aggregate itself.
+	var counts int
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		err := deps.DB.QueryRow(`SELECT counts FROM counter_aggregate`).Scan(&counts)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("reading the aggregate: %v", err)
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the worker never aggregated the burst")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Give a second, unwanted run the chance to show up.
+	time.Sleep(deps.AggregateDebounce * 2)
+
+	var rows int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter_aggregate`).Scan(&rows); err != nil {
+		t.Fatalf("counting aggregates: %v", err)
+	}
+
+	if rows != 1 || counts != 5 {
+		t.Errorf("expected the burst to be aggregated once to 5, got %d aggregate rows and %d", rows, counts)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.AggregateDebounce = time.Millisecond * 10
+	deps.ReadYourWrites = true
+	// The writers and the aggregator contend for the database, the server
+	// retries that by default.
+	deps.TxMaxAttempts = 5
+	silenceLog(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deps.RunAggregator(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// With no aggregate at all List sums the events, which would hide a
+	// stale one.
+	createAggregate(t, deps)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("add: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+				return
+			}
+
+			var added AddResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &added); err != nil || added.Count == nil {
+				t.Errorf("add: decoding response body %s: %v", rec.Body.String(), err)
+				return
+			}
+
+			rec = httptest.NewRecorder()
+			deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+
+			var listed ListResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+				t.Errorf("list: decoding response body %s: %v", rec.Body.String(), err)
+				return
+			}
+
+			if listed.Counter < *added.Count {
+				t.Errorf("expected List to include the increment that made %d, got %d", *added.Count, listed.Counter)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestPruneAggregates(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.AggregateKeep = 2
+	silenceLog(t)
+
+	start := time.Date(2022, time.July, 19, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		now := start.Add(time.Minute * time.Duration(i))
+		deps.Now = func() time.Time {
+			return now
+		}
+
+		doAdd(t, deps)
+		createAggregate(t, deps)
+	}
+
+	if err := deps.PruneAggregates(context.Background()); err != nil {
+		t.Fatalf("pruning aggregates: %v", err)
+	}
+
+	var rows int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter_aggregate`).Scan(&rows); err != nil {
+		t.Fatalf("counting aggregates: %v", err)
+	}
+
+	if rows != 2 {
+		t.Errorf("expected 2 aggregate rows to be kept, got %d", rows)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(5) {
+		t.Errorf("expected counter to still be 5, got %v", body["counter"])
+	}
+}
+
+func TestHourlyHistogram(t *testing.T) {
+	deps := newTestDeps(t)
+
+	for _, at := range []string{
+		"2022-07-19T08:15:00Z",
+		"2022-07-19T08:45:00Z",
+		"2022-07-20T08:05:00Z",
+		"2022-07-20T23:59:00Z",
+	} {
+		addAt(t, deps, at)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.HourlyHistogram(rec, httptest.NewRequest(http.MethodGet, "/api/stats/hourly", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var hours []int
+	if err := json.Unmarshal(rec.Body.Bytes(), &hours); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	want := make([]int, 24)
+	want[8] = 3
+	want[23] = 1
+
+	if fmt.Sprint(hours) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, hours)
+	}
+
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+	deps.Location = jakarta
+
+	rec = httptest.NewRecorder()
+	deps.HourlyHistogram(rec, httptest.NewRequest(http.MethodGet, "/api/stats/hourly", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &hours); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	want = make([]int, 24)
+	want[15] = 3
+	want[6] = 1
+
+	if fmt.Sprint(hours) != fmt.Sprint(want) {
+		t.Errorf("expected %v in Asia/Jakarta, got %v", want, hours)
+	}
+}
+
+// addAt backfills a single increment at the given RFC3339 timestamp.
+func TestWeekday(t *testing.T) {
+	deps := newTestDeps(t)
+
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+	deps.Location = jakarta
+
+	// A Monday, a late Tuesday that is already Wednesday in Jakarta, and a
+	// Saturday.
+	for _, at := range []string{
+		"2022-07-18T08:00:00Z",
+		"2022-07-19T20:00:00Z",
+		"2022-07-23T08:00:00Z",
+		"2022-07-23T09:00:00Z",
+	} {
+		addAt(t, deps, at)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Weekday(rec, httptest.NewRequest(http.MethodGet, "/api/stats/weekday", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got, want := rec.Body.String(), `[0,1,0,1,0,0,2]`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func addAt(t testing.TB, deps *Deps, at string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"at":"`+at+`"}`)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   float64
+	}{
+		{name: "no events", events: nil, want: 0},
+		{name: "single event", events: []string{"2022-07-19T08:00:00Z"}, want: 1},
+		{
+			name:   "same day",
+			events: []string{"2022-07-19T08:00:00Z", "2022-07-19T09:00:00Z", "2022-07-19T10:00:00Z"},
+			want:   3,
+		},
+		{
+			name: "over four days",
+			events: []string{
+				"2022-07-15T08:00:00Z",
+				"2022-07-16T08:00:00Z",
+				"2022-07-16T09:00:00Z",
+				"2022-07-17T08:00:00Z",
+				"2022-07-19T08:00:00Z",
+			},
+			want: 1.25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps(t)
+			for _, at := range tt.events {
+				addAt(t, deps, at)
+			}
+
+			rec := httptest.NewRecorder()
+			deps.Rate(rec, httptest.NewRequest(http.MethodGet, "/api/stats/rate", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+
+			var body map[string]float64
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if body["ratePerDay"] != tt.want {
+				t.Errorf("expected ratePerDay %v, got %v", tt.want, body["ratePerDay"])
+			}
+		})
+	}
+}
+
+func TestStreak(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		events   []string
+		current  int
+		longest  int
+	}{
+		{name: "no events", current: 0, longest: 0},
+		{
+			name: "ongoing",
+			events: []string{
+				"2022-07-12T08:00:00Z",
+				"2022-07-13T08:00:00Z",
+				"2022-07-18T08:00:00Z",
+				"2022-07-19T08:00:00Z",
+				"2022-07-19T09:00:00Z",
+				"2022-07-20T08:00:00Z",
+			},
+			current: 3,
+			longest: 3,
+		},
+		{
+			name:    "ended yesterday",
+			events:  []string{"2022-07-17T08:00:00Z", "2022-07-18T08:00:00Z", "2022-07-19T08:00:00Z"},
+			current: 3,
+			longest: 3,
+		},
+		{
+			name: "broken",
+			events: []string{
+				"2022-07-10T08:00:00Z",
+				"2022-07-11T08:00:00Z",
+				"2022-07-12T08:00:00Z",
+				"2022-07-18T08:00:00Z",
+			},
+			current: 0,
+			longest: 3,
+		},
+		{
+			// 18:00 UTC is already the next day in Jakarta.
+			name:     "timezone",
+			location: "Asia/Jakarta",
+			events:   []string{"2022-07-18T08:00:00Z", "2022-07-18T18:00:00Z"},
+			current:  2,
+			longest:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps(t)
+			deps.Now = func() time.Time {
+				return time.Date(2022, time.July, 20, 12, 0, 0, 0, time.UTC)
+			}
+
+			if tt.location != "" {
+				location, err := time.LoadLocation(tt.location)
+				if err != nil {
+					t.Fatalf("loading location: %v", err)
+				}
+
+				deps.Location = location
+			}
+
+			for _, at := range tt.events {
+				addAt(t, deps, at)
+			}
+
+			rec := httptest.NewRecorder()
+			deps.Streak(rec, httptest.NewRequest(http.MethodGet, "/api/stats/streak", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+
+			var body map[string]int
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if body["current"] != tt.current || body["longest"] != tt.longest {
+				t.Errorf("expected current %d and longest %d, got %v", tt.current, tt.longest, body)
+			}
+		})
+	}
+}
+
+func TestCountAsOf(t *testing.T) {
+	deps := newTestDeps(t)
+
+	addAt(t, deps, "2024-01-15T10:00:00Z")
+	addAt(t, deps, "2024-01-31T23:59:59Z")
+	addAt(t, deps, "2024-02-01T06:00:00+07:00")
+	addAt(t, deps, "2024-02-10T10:00:00Z")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCount  float64
+	}{
+		{name: "current total", query: "", wantStatus: http.StatusOK, wantCount: 4},
+		{name: "end of january", query: "?asOf=2024-01-31T23:59:59Z", wantStatus: http.StatusOK, wantCount: 3},
+		{name: "before anything", query: "?asOf=2023-12-31T00:00:00Z", wantStatus: http.StatusOK, wantCount: 0},
+		{name: "invalid timestamp", query: "?asOf=2024-01-31", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			deps.CountAsOf(rec, httptest.NewRequest(http.MethodGet, "/api/count"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if body["count"] != tt.wantCount {
+				t.Errorf("expected count %v, got %v", tt.wantCount, body["count"])
+			}
+		})
+	}
+}
+
+func TestRecompute(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	doAdd(t, deps)
+
+	rec := httptest.NewRecorder()
+	deps.Recompute(rec, httptest.NewRequest(http.MethodGet, "/api/recompute", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for GET, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Recompute(rec, httptest.NewRequest(http.MethodPost, "/api/recompute", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(1) {
+		t.Errorf("expected counter to be 1, got %v", body["counter"])
+	}
+
+	if _, err := deps.DB.Exec(`DROP TABLE counter_aggregate`); err != nil {
+		t.Fatalf("dropping table: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Recompute(rec, httptest.NewRequest(http.MethodPost, "/api/recompute", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d when aggregation fails, got %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestAddValidation(t *testing.T) {
+	deps := newTestDeps(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantErrors map[string]string
+	}{
+		{name: "empty body", body: "", wantStatus: http.StatusOK},
+		{name: "empty object", body: `{}`, wantStatus: http.StatusOK},
+		{
+			name:       "every field",
+			body:       `{"amount":2,"reason":"bumped into a chair","subject":"raymond","at":"2022-07-19T08:00:00Z"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "non positive amount",
+			body:       `{"amount":0}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"amount": "must be positive"},
+		},
+		{
+			name:       "amount of the wrong type",
+			body:       `{"amount":"two"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"amount": "must be of type int"},
+		},
+		{name: "weight", body: `{"weight":3}`, wantStatus: http.StatusOK},
+		{
+			name:       "weight out of range",
+			body:       `{"weight":6}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"weight": "must be between 1 and 5"},
+		},
+		{
+			name:       "weight and amount",
+			body:       `{"weight":2,"amount":2}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"weight": "cannot be combined with amount"},
+		},
+		{
+			name:       "unknown subject",
+			body:       `{"subject":"Someone else"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"subject": "must be one of Raymond"},
+		},
+		{
+			name:       "unknown field",
+			body:       `{"amonut":2}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"amonut": "unknown field"},
+		},
+		{
+			name:       "several invalid fields",
+			body:       `{"amount":-1,"at":"tomorrow"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"amount": "must be positive", "at": "must be an RFC3339 timestamp"},
+		},
+		{
+			name:       "not an object",
+			body:       `[1]`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: map[string]string{"body": "must be a valid JSON object"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if tt.wantErrors == nil {
+				return
+			}
+
+			var body struct {
+				Errors map[string]string `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if fmt.Sprint(body.Errors) != fmt.Sprint(tt.wantErrors) {
+				t.Errorf("expected errors %v, got %v", tt.wantErrors, body.Errors)
+			}
+		})
+	}
+
+	var total int
+	var reason string
+	if err := deps.DB.QueryRow(`SELECT SUM(count), MAX(reason) FROM counter`).Scan(&total, &reason); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if total != 7 {
+		t.Errorf("expected only the valid requests to be counted, got a total of %d", total)
+	}
+
+	if reason != "bumped into a chair" {
+		t.Errorf("expected the reason to be stored, got %q", reason)
+	}
+}
+
+func TestListCache(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.ListCacheTTL = time.Minute
+
+	if body := doList(t, deps); body["counter"] != float64(0) {
+		t.Fatalf("expected counter to be 0, got %v", body["counter"])
+	}
+
+	// Written behind the app's back, so nothing invalidates the cache.
+	_, err := deps.DB.Exec(`INSERT INTO counter_aggregate (counts, created_at) VALUES (?, ?)`, 42, time.Now())
+	if err != nil {
+		t.Fatalf("inserting aggregate: %v", err)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(0) {
+		t.Errorf("expected the cached counter of 0, got %v", body["counter"])
+	}
+
+	doAdd(t, deps)
+
+	if body := doList(t, deps); body["counter"] != float64(42) {
+		t.Errorf("expected the cache to be invalidated by Add, got %v", body["counter"])
+	}
+}
+
+func TestListLongPoll(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	t.Run("returns right away when the count differs", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=30&since=0", nil))
+		if !strings.Contains(rec.Body.String(), `"counter":1`) {
+			t.Errorf("expected counter 1, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("returns once the count changes", func(t *testing.T) {
+		done := make(chan string)
+		go func() {
+			rec := httptest.NewRecorder()
+			deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=30&since=1", nil))
+			done <- rec.Body.String()
+		}()
+
+		time.Sleep(time.Millisecond * 50)
+		doAdd(t, deps)
+		createAggregate(t, deps)
+
+		select {
+		case body := <-done:
+			if !strings.Contains(body, `"counter":2`) {
+				t.Errorf("expected counter 2, got %s", body)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("long poll did not return after the count changed")
+		}
+	})
+
+	t.Run("returns the current value at timeout", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=1&since=2", nil))
+		if !strings.Contains(rec.Body.String(), `"counter":2`) {
+			t.Errorf("expected counter 2, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("rejects an invalid wait", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=3600", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	// Runs last, the hub stays closed afterwards.
+	t.Run("returns the current value on shutdown", func(t *testing.T) {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			rec := httptest.NewRecorder()
+			deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=30&since=2", nil))
+			done <- rec
+		}()
+
+		time.Sleep(time.Millisecond * 50)
+		deps.updates.close()
+
+		select {
+		case rec := <-done:
+			if !strings.Contains(rec.Body.String(), `"counter":2`) {
+				t.Errorf("expected counter 2, got %s", rec.Body.String())
+			}
+
+			if got := rec.Header().Get("Connection"); got != "close" {
+				t.Errorf("expected the connection to be closed, got %q", got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("long poll did not return on shutdown")
+		}
+	})
+}
+
+func TestHead(t *testing.T) {
+	deps := newTestDeps(t)
+
+	rec := httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodHead, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("index: expected an empty %d, got %d with %dB", http.StatusOK, rec.Code, rec.Body.Len())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "text/html" {
+		t.Errorf("index: expected Content-Type text/html, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.List(rec, httptest.NewRequest(http.MethodHead, "/api/list", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("list: expected an empty %d, got %d with %dB", http.StatusOK, rec.Code, rec.Body.Len())
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("list: expected an ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/list", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	deps.List(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("list: expected status %d for a matching ETag, got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func TestSubtractSoftDeletes(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	subtract := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		deps.Subtract(rec, httptest.NewRequest(http.MethodPost, "/api/subtract", nil))
+		return rec
+	}
+
+	if rec := subtract(); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d on an empty counter, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+	addAt(t, deps, "2022-07-19T09:00:00Z")
+
+	rec := subtract()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	if body["count"] != float64(1) {
+		t.Errorf("expected count to be 1, got %v", body["count"])
+	}
+
+	var rows, deleted int
+	var deletedCreatedAt time.Time
+	err := deps.DB.QueryRow(`SELECT COUNT(*), COUNT(deleted_at) FROM counter`).Scan(&rows, &deleted)
+	if err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if rows != 2 || deleted != 1 {
+		t.Errorf("expected 2 rows with 1 soft-deleted, got %d rows with %d soft-deleted", rows, deleted)
+	}
+
+	err = deps.DB.QueryRow(`SELECT created_at FROM counter WHERE deleted_at IS NOT NULL`).Scan(&deletedCreatedAt)
+	if err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if want := time.Date(2022, time.July, 19, 9, 0, 0, 0, time.UTC); !deletedCreatedAt.Equal(want) {
+		t.Errorf("expected the latest event to be deleted, got the one at %s", deletedCreatedAt)
+	}
+
+	createAggregate(t, deps)
+	if body := doList(t, deps); body["counter"] != float64(1) {
+		t.Errorf("expected the aggregate to skip deleted events, got %v", body["counter"])
+	}
+
+	if body := doAdd(t, deps); body["count"] != float64(2) {
+		t.Errorf("expected Add to skip deleted events, got %v", body["count"])
+	}
+}
+
+func TestNamedCounters(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	add := func(name string) {
+		rec := httptest.NewRecorder()
+		deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add?name="+name, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("add %s: expected status %d, got %d: %s", name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+	}
+
+	list := func(name string) string {
+		rec := httptest.NewRecorder()
+		deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?name="+name, nil))
+		return rec.Body.String()
+	}
+
+	doAdd(t, deps)
+	add("thank-you")
+	add("Thank-You")
+
+	for _, name := range []string{defaultCounterName, "thank-you"} {
+		if err := deps.CreateAggregate(context.Background(), name); err != nil {
+			t.Fatalf("creating aggregate of %s: %v", name, err)
+		}
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(1) {
+		t.Errorf("expected the default counter to be 1, got %v", body["counter"])
+	}
+
+	if body := list("thank-you"); !strings.Contains(body, `"counter":2`) {
+		t.Errorf("expected thank-you to be 2, got %s", body)
+	}
+
+	if body := list("late-to-standup"); !strings.Contains(body, `"counter":0`) {
+		t.Errorf("expected an unused counter to be 0, got %s", body)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?name=no%20spaces", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid name, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestMigrateNamesExistingRows(t *testing.T) {
+	deps := newTestDeps(t)
+
+	// A schema from before names had no index on them either.
+	if _, err := deps.DB.Exec(`DROP INDEX counter_name`); err != nil {
+		t.Fatalf("dropping index: %v", err)
+	}
+
+	if _, err := deps.DB.Exec(`ALTER TABLE counter DROP COLUMN name`); err != nil {
+		t.Fatalf("dropping name: %v", err)
+	}
+
+	if _, err := deps.DB.Exec(`INSERT INTO counter (count, created_at) VALUES (1, ?)`, time.Now()); err != nil {
+		t.Fatalf("inserting legacy row: %v", err)
+	}
+
+	if err := deps.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	var name string
+	if err := deps.DB.QueryRow(`SELECT name FROM counter`).Scan(&name); err != nil {
+		t.Fatalf("reading name: %v", err)
+	}
+
+	if name != defaultCounterName {
+		t.Errorf("expected existing rows to belong to %q, got %q", defaultCounterName, name)
+	}
+}
+
+func TestServerAddThenList(t *testing.T) {
+	baseURL, cleanup := newTestServer(t)
+	defer cleanup()
+	silenceLog(t)
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(baseURL+"/api/add", "application/json", nil)
+		if err != nil {
+			t.Fatalf("adding: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("add: expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		resp, err := http.Get(baseURL + "/api/list")
+		if err != nil {
+			t.Fatalf("listing: %v", err)
+		}
+
+		var body map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decoding response body: %v", err)
+		}
+
+		if body["counter"] == float64(3) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the aggregate to reach 3, got %v", body["counter"])
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestAddWeight(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.DefaultWeight = 2
+
+	if body := doAdd(t, deps); body["count"] != float64(2) || body["added"] != float64(2) {
+		t.Errorf("expected the default weight to be added, got %v", body)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"weight":5}`)))
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	if body["count"] != float64(7) || body["added"] != float64(5) {
+		t.Errorf("expected a weight of 5 to bring the count to 7, got %v", body)
+	}
+}
+
+func TestMigrateAddsCounterID(t *testing.T) {
+	deps := newTestDeps(t)
+
+	statements := []string{
+		`DROP TABLE counter`,
+		`CREATE TABLE counter (count INTEGER NOT NULL, created_at DATETIME NOT NULL)`,
+		`INSERT INTO counter (rowid, count, created_at) VALUES (7, 1, '2022-07-19 08:00:00+00:00')`,
+	}
+	for _, statement := range statements {
+		if _, err := deps.DB.Exec(statement); err != nil {
+			t.Fatalf("preparing legacy table: %v", err)
+		}
+	}
+
+	if err := deps.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	var id int64
+	var name string
+	if err := deps.DB.QueryRow(`SELECT id, name FROM counter`).Scan(&id, &name); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+
+	if id != 7 || name != defaultCounterName {
+		t.Errorf("expected the row to keep rowid 7 as its id, got id %d and name %q", id, name)
+	}
+}
+
+func TestListContentNegotiation(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	tests := []struct {
+		accept          string
+		wantContentType string
+	}{
+		{accept: "", wantContentType: "application/json"},
+		{accept: "*/*", wantContentType: "application/json"},
+		{accept: "application/json", wantContentType: "application/json"},
+		{accept: "text/html", wantContentType: "text/html; charset=utf-8"},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", wantContentType: "text/html; charset=utf-8"},
+		{accept: "text/html;q=0.5, application/json", wantContentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/list", nil)
+			req.Header.Set("Accept", tt.accept)
+
+			rec := httptest.NewRecorder()
+			deps.List(rec, req)
+
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("expected content type %q, got %q", tt.wantContentType, got)
+			}
+
+			if strings.HasPrefix(tt.wantContentType, "text/html") && !strings.Contains(rec.Body.String(), "<strong>1</strong>") {
+				t.Errorf("expected the count in the page, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 22, 8, 0, 0, 0, time.UTC)
+	}
+
+	since := func() string {
+		rec := httptest.NewRecorder()
+		deps.Since(rec, httptest.NewRequest(http.MethodGet, "/api/since", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		return rec.Body.String()
+	}
+
+	if got := since(); got != `{"lastDate":null,"secondsSince":-1}` {
+		t.Errorf("unexpected body for an empty counter %s", got)
+	}
+
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+	addAt(t, deps, "2022-07-19T08:00:00+07:00")
+
+	if got := since(); got != `{"lastDate":"2022-07-19T08:00:00+07:00","secondsSince":284400}` {
+		t.Errorf("unexpected body %s", got)
+	}
+}
+
+func TestOnAddHook(t *testing.T) {
+	deps := newTestDeps(t)
+
+	type call struct{ count, total int }
+	calls := make(chan call, 1)
+	deps.Hooks.OnAdd = func(ctx context.Context, count int, total int) {
+		calls <- call{count, total}
+	}
+
+	doAdd(t, deps)
+
+	select {
+	case got := <-calls:
+		if got.count != 1 || got.total != 1 {
+			t.Errorf("expected OnAdd(1, 1), got OnAdd(%d, %d)", got.count, got.total)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnAdd was never called")
+	}
+}
+
+func TestMonthly(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 20, 12, 0, 0, 0, time.UTC)
+	}
+
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("loading location: %v", err)
+	}
+	deps.Location = jakarta
+
+	addAt(t, deps, "2022-03-31T12:00:00Z")
+	addAt(t, deps, "2022-05-31T18:00:00Z")
+	addAt(t, deps, "2022-07-01T08:00:00Z")
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+
+	rec := httptest.NewRecorder()
+	deps.Monthly(rec, httptest.NewRequest(http.MethodGet, "/api/stats/monthly?months=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// 2022-05-31T18:00:00Z is already June in Jakarta, March is out of range.
+	want := `[{"month":"2022-05","count":0},{"month":"2022-06","count":1},{"month":"2022-07","count":2}]`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Monthly(rec, httptest.NewRequest(http.MethodGet, "/api/stats/monthly?months=121", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for too many months, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 20, 12, 30, 0, 0, time.UTC)
+	}
+
+	addAt(t, deps, "2022-07-20T10:15:00Z")
+	addAt(t, deps, "2022-07-20T10:45:00Z")
+	addAt(t, deps, "2022-07-20T12:00:00Z")
+	addAt(t, deps, "2022-07-19T12:00:00Z")
+
+	rec := httptest.NewRecorder()
+	deps.Series(rec, httptest.NewRequest(http.MethodGet, "/api/stats/series?interval=1h&from=2022-07-20T10:00:00Z&to=2022-07-20T12:00:00Z", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	want := `[{"bucketStart":"2022-07-20T10:00:00Z","count":2},{"bucketStart":"2022-07-20T11:00:00Z","count":0},{"bucketStart":"2022-07-20T12:00:00Z","count":1}]`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	// Without bounds the last 24 intervals up to now are returned.
+	rec = httptest.NewRecorder()
+	deps.Series(rec, httptest.NewRequest(http.MethodGet, "/api/stats/series?interval=1d", nil))
+
+	var series []seriesBucket
+	if err := json.Unmarshal(rec.Body.Bytes(), &series); err != nil {
+		t.Fatalf("decoding series: %v", err)
+	}
+
+	if len(series) != 24 || series[23].Count != 3 || series[22].Count != 1 {
+		t.Errorf("expected 24 days ending with 1 and 3, got %v", series)
+	}
+
+	for _, query := range []string{"interval=1x", "interval=30s", "interval=1m&from=2022-01-01T00:00:00Z", "from=2022-07-21T00:00:00Z"} {
+		rec = httptest.NewRecorder()
+		deps.Series(rec, httptest.NewRequest(http.MethodGet, "/api/stats/series?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	deps := newTestDeps(t)
+	// A Wednesday.
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 20, 12, 0, 0, 0, time.UTC)
+	}
+
+	for _, at := range []string{
+		"2022-07-12T08:00:00Z",
+		"2022-07-14T08:00:00Z",
+		"2022-07-18T08:00:00Z",
+		"2022-07-19T08:00:00Z",
+		"2022-07-20T08:00:00Z",
+	} {
+		addAt(t, deps, at)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "",
+			want:  `{"current":{"count":3,"start":"2022-07-18T00:00:00Z","end":"2022-07-25T00:00:00Z"},"deltaPercent":50,"period":"week","previous":{"count":2,"start":"2022-07-11T00:00:00Z","end":"2022-07-18T00:00:00Z"}}`,
+		},
+		{
+			query: "?period=day",
+			want:  `{"current":{"count":1,"start":"2022-07-20T00:00:00Z","end":"2022-07-21T00:00:00Z"},"deltaPercent":0,"period":"day","previous":{"count":1,"start":"2022-07-19T00:00:00Z","end":"2022-07-20T00:00:00Z"}}`,
+		},
+		{
+			query: "?period=month",
+			want:  `{"current":{"count":5,"start":"2022-07-01T00:00:00Z","end":"2022-08-01T00:00:00Z"},"deltaPercent":null,"period":"month","previous":{"count":0,"start":"2022-06-01T00:00:00Z","end":"2022-07-01T00:00:00Z"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		deps.Compare(rec, httptest.NewRequest(http.MethodGet, "/api/stats/compare"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("expected %s for %q, got %s", tt.want, tt.query, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Compare(rec, httptest.NewRequest(http.MethodGet, "/api/stats/compare?period=year", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown period, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestAddDryRun(t *testing.T) {
+	deps := newTestDeps(t)
+	doAdd(t, deps)
+	doAdd(t, deps)
+
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add?dryRun=true", strings.NewReader(`{"weight":3}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	if body["message"] != "dry-run" || body["count"] != float64(5) {
+		t.Errorf("expected a dry-run total of 5, got %v", body)
+	}
+
+	var rows int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter`).Scan(&rows); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+
+	if rows != 2 {
+		t.Errorf("expected a dry run not to insert anything, got %d rows", rows)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add?dryRun=true", strings.NewReader(`{"weight":9}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a dry run to still validate, got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add?dryRun=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a malformed dryRun, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestAddCooldown(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.AddCooldown = time.Second * 10
+
+	now := time.Date(2022, time.July, 19, 8, 0, 0, 0, time.UTC)
+	deps.Now = func() time.Time { return now }
+
+	doAdd(t, deps)
+
+	now = now.Add(time.Millisecond * 2500)
+
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusTooManyRequests, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != "8" {
+		t.Errorf("expected Retry-After 8, got %q", got)
+	}
+
+	var body problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	if body.RetryAfter != 8 {
+		t.Errorf("expected retryAfter 8, got %d", body.RetryAfter)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add?name=other", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected another counter not to be held back, got status %d", rec.Code)
+	}
+
+	now = now.Add(time.Second * 8)
+
+	if body := doAdd(t, deps); body["count"] != float64(2) {
+		t.Errorf("expected the add after the cooldown to count, got %v", body["count"])
+	}
+}
+
+func TestListTrend(t *testing.T) {
+	deps := newTestDeps(t)
+	// A Wednesday.
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 20, 12, 0, 0, 0, time.UTC)
+	}
+
+	for _, at := range []string{
+		"2022-07-12T08:00:00Z",
+		"2022-07-14T08:00:00Z",
+		"2022-07-18T08:00:00Z",
+		"2022-07-19T08:00:00Z",
+		"2022-07-20T08:00:00Z",
+	} {
+		addAt(t, deps, at)
+	}
+	createAggregate(t, deps)
+
+	tests := []struct {
+		period string
+		want   string
+	}{
+		{period: "day", want: `{"period":"day","current":1,"previous":1,"change":0}`},
+		{period: "week", want: `{"period":"week","current":3,"previous":2,"change":50}`},
+		{period: "month", want: `{"period":"month","current":5,"previous":0,"change":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?period="+tt.period, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response body: %v", err)
+			}
+
+			if got := string(body["trend"]); got != tt.want {
+				t.Errorf("expected trend %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	deps.List(rec, httptest.NewRequest(http.MethodGet, "/api/list?period=year", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown period, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if body := doList(t, deps); body["trend"] != nil {
+		t.Errorf("expected no trend without a period, got %v", body["trend"])
+	}
+}
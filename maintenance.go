@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// maintenanceRetryAfter is the Retry-After, in seconds, sent with writes
+// rejected during maintenance.
+const maintenanceRetryAfter = 60
+
+// InMaintenance reports whether writes are currently rejected.
+func (d *Deps) InMaintenance() bool {
+	return atomic.LoadInt32(&d.maintenance) == 1
+}
+
+// SetMaintenance turns maintenance mode on or off, logging when it flips.
+func (d *Deps) SetMaintenance(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&d.maintenance, v) == v {
+		return
+	}
+
+	if enabled {
+		log.Println("Maintenance mode enabled, rejecting writes")
+	} else {
+		log.Println("Maintenance mode disabled")
+	}
+}
+
+// Maintenance answers every write with 503 while maintenance mode is on, so
+// the database can be backed up or migrated. Reads keep working, and so does
+// the toggle at /api/maintenance.
+func (d *Deps) Maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.InMaintenance() || r.Method == http.MethodGet || r.Method == http.MethodHead || r.URL.Path == "/api/maintenance" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+		writeProblem(w, http.StatusServiceUnavailable, "the counter is read-only during maintenance")
+	})
+}
+
+// MaintenanceToggle reports maintenance mode on GET and switches it with a
+// POST of {"enabled":true} or {"enabled":false}.
+func (d *Deps) MaintenanceToggle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var request struct {
+			Enabled *bool `json:"enabled"`
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			writeValidationErrors(w, decodeErrors(err))
+			return
+		}
+
+		if request.Enabled == nil {
+			writeValidationErrors(w, map[string]string{"enabled": "is required"})
+			return
+		}
+
+		d.SetMaintenance(*request.Enabled)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{"maintenance": d.InMaintenance()})
+}
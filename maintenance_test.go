@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	silenceLog(t)
+
+	server := httptest.NewServer(deps.Handler(deps.Routes()))
+	defer server.Close()
+	baseURL := server.URL
+
+	toggle := func(body string) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/api/maintenance", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("toggling maintenance: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("toggle: expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	toggle(`{"enabled":true}`)
+
+	resp, err := http.Post(baseURL+"/api/add", "application/json", nil)
+	if err != nil {
+		t.Fatalf("adding: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d during maintenance, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	if got := resp.Header.Get("Retry-After"); got == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	for _, path := range []string{"/api/list", "/"} {
+		resp, err := http.Get(baseURL + path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected %s to stay readable, got status %d", path, resp.StatusCode)
+		}
+	}
+
+	toggle(`{"enabled":false}`)
+
+	resp, err = http.Post(baseURL+"/api/add", "application/json", nil)
+	if err != nil {
+		t.Fatalf("adding: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected writes to work again, got status %d", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceToggleRequiresAPIKey(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	handler := deps.RequireAPIKey(deps.MaintenanceToggle)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/api/maintenance", strings.NewReader(`{"enabled":true}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if deps.InMaintenance() {
+		t.Error("expected maintenance mode to stay off")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type endpointMetrics struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+}
+
+// requestMetrics counts requests and server errors per route. Its zero value
+// is ready to use.
+type requestMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointMetrics
+}
+
+func (m *requestMetrics) record(endpoint string, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.endpoints == nil {
+		m.endpoints = make(map[string]*endpointMetrics)
+	}
+
+	e, ok := m.endpoints[endpoint]
+	if !ok {
+		e = &endpointMetrics{}
+		m.endpoints[endpoint] = e
+	}
+
+	e.Requests++
+	if failed {
+		e.Errors++
+	}
+}
+
+func (m *requestMetrics) snapshot() map[string]endpointMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]endpointMetrics, len(m.endpoints))
+	for endpoint, e := range m.endpoints {
+		snapshot[endpoint] = *e
+	}
+
+	return snapshot
+}
+
+// CountRequests records every request passed to next under the pattern mux
+// routes it to, so unknown paths don't each get their own entry. Responses
+// with a 5xx status, and handlers that panic, count as errors. It goes around
+// the middleware, so the status counted is the one the client got, including
+// the rejections of Maintenance, Timeout and LimitBody.
+func (d *Deps) CountRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				d.metrics.record(pattern, true)
+				panic(err)
+			}
+
+			d.metrics.record(pattern, recorder.status >= http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// RequestStats reports the request and error counts of every endpoint since
+// the server started. With the write buffer enabled, the number of increments
+// waiting to be written is reported under writeBuffer. With a WEBHOOK_URL the
+// outcome of the notifications is reported under webhook.
+func (d *Deps) RequestStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{}
+	for endpoint, e := range d.metrics.snapshot() {
+		stats[endpoint] = e
+	}
+
+	if d.EnableWriteBuffer {
+		stats["writeBuffer"] = map[string]int{"queued": d.writeBuffer.depth()}
+	}
+
+	if d.WebhookURL != "" {
+		stats["webhook"] = d.webhooks.snapshot()
+	}
+
+	d.writeJSON(w, r, http.StatusOK, stats)
+}
+
+// Metrics writes the counter totals and the request metrics in the Prometheus
+// text exposition format, so they can be scraped without pulling in the
+// client library.
+func (d *Deps) Metrics(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT name, COALESCE(SUM(count), 0) FROM counter WHERE deleted_at IS NULL GROUP BY name ORDER BY name`,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	var body bytes.Buffer
+	body.WriteString("# HELP raymond_sorry_total Sum of every event that was not subtracted, per counter.\n")
+	body.WriteString("# TYPE raymond_sorry_total gauge\n")
+	for rows.Next() {
+		var name string
+		var total int
+		if err := rows.Scan(&name, &total); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		fmt.Fprintf(&body, "raymond_sorry_total{name=\"%s\"} %d\n", escapeLabel(name), total)
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	snapshot := d.metrics.snapshot()
+	endpoints := make([]string, 0, len(snapshot))
+	for endpoint := range snapshot {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	body.WriteString("# HELP raymond_http_requests_total Requests served, per endpoint.\n")
+	body.WriteString("# TYPE raymond_http_requests_total counter\n")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(&body, "raymond_http_requests_total{endpoint=\"%s\"} %d\n", escapeLabel(endpoint), snapshot[endpoint].Requests)
+	}
+
+	body.WriteString("# HELP raymond_http_request_errors_total Requests answered with a 5xx status, per endpoint.\n")
+	body.WriteString("# TYPE raymond_http_request_errors_total counter\n")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(&body, "raymond_http_request_errors_total{endpoint=\"%s\"} %d\n", escapeLabel(endpoint), snapshot[endpoint].Errors)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCountRequests(t *testing.T) {
+	deps := &Deps{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/api/stats/requests", deps.RequestStats)
+	handler := deps.Recover(deps.CountRequests(mux, mux))
+	silenceLog(t)
+
+	for _, path := range []string{"/ok", "/ok", "/fail", "/panic", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/requests", nil))
+
+	var got map[string]endpointMetrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+
+	want := map[string]endpointMetrics{
+		"/ok":       {Requests: 2},
+		"/fail":     {Requests: 1, Errors: 1},
+		"/panic":    {Requests: 1, Errors: 1},
+		"unmatched": {Requests: 1},
+	}
+	for endpoint, metrics := range want {
+		if got[endpoint] != metrics {
+			t.Errorf("expected %s to have %+v, got %+v", endpoint, metrics, got[endpoint])
+		}
+	}
+}
+
+func TestCountRequestsMiddleware(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.SetMaintenance(true)
+	silenceLog(t)
+
+	handler := deps.Handler(deps.Routes())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d during maintenance, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	if got := deps.metrics.snapshot()["/api/add"]; got != (endpointMetrics{Requests: 1, Errors: 1}) {
+		t.Errorf("expected the maintenance rejection to count as an error, got %+v", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	deps := newTestDeps(t)
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+	deps.metrics.record("/api/add", false)
+	deps.metrics.record("/api/add", true)
+
+	rec := httptest.NewRecorder()
+	deps.Metrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	for _, line := range []string{
+		`raymond_sorry_total{name="sorry"} 2`,
+		`raymond_http_requests_total{endpoint="/api/add"} 2`,
+		`raymond_http_request_errors_total{endpoint="/api/add"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("expected %q in the exposition, got:\n%s", line, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// statusRecorder wraps a http.ResponseWriter to remember the status code and
+// the number of body bytes written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Flush keeps streaming responses working through the wrapper.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// clientIP returns the address of the client that made the request. Behind a
+// trusted proxy it walks X-Forwarded-For from the right and returns the first
+// hop that is not one of our own proxies.
+func (d *Deps) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !d.TrustProxy {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	hops = append(hops, remote)
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// Whoever sent this hop is not trustworthy, return the last hop
+			// we could make sense of.
+			if i < len(hops)-1 {
+				return hops[i+1]
+			}
+
+			return remote
+		}
+
+		if i == len(hops)-1 && len(d.TrustedProxies) == 0 {
+			continue
+		}
+
+		if !d.isTrustedProxy(ip) {
+			return hops[i]
+		}
+	}
+
+	return hops[0]
+}
+
+func (d *Deps) isTrustedProxy(ip net.IP) bool {
+	for _, network := range d.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccessLog logs the method, path, status, response size, duration and client
+// address of every request.
+func (d *Deps) AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		log.Printf(
+			"%s %s %d %dB %s %s",
+			r.Method,
+			r.URL.RequestURI(),
+			status,
+			recorder.bytes,
+			time.Since(start),
+			d.clientIP(r),
+		)
+	})
+}
+
+// Recover catches a panicking handler, logs it along with the stack trace and
+// responds with a 500 instead of dropping the connection. In Development the
+// stack trace is also sent to the client.
+func (d *Deps) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			// http.ErrAbortHandler is the documented way to abort a response,
+			// the server already handles it quietly.
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			log.Printf(
+				"panic serving %s %s (request id %q): %v\n%s",
+				r.Method,
+				r.URL.Path,
+				r.Header.Get("X-Request-Id"),
+				v,
+				stack,
+			)
+
+			p := newProblem(http.StatusInternalServerError, "internal server error")
+			if d.Development {
+				p.Detail = fmt.Sprint(v)
+				p.Stack = string(stack)
+			}
+			p.write(w)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ForceHTTPS redirects plain HTTP requests to https with a 308, so the method
+// and body survive. TLS is expected to end at a proxy, the original scheme is
+// only known from X-Forwarded-Proto and therefore only with TrustProxy. The
+// API, /metrics and /readyz are left alone, clients and probes rarely follow
+// redirects.
+func (d *Deps) ForceHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.ForceHTTPSRedirect || !d.TrustProxy || r.TLS != nil || skipsHTTPSRedirect(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if d.forwardedProto(r) != "http" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// forwardedProto returns the scheme the client used according to
+// X-Forwarded-Proto, or an empty string when the header is missing or the
+// peer is not a proxy we trust.
+func (d *Deps) forwardedProto(r *http.Request) string {
+	if len(d.TrustedProxies) > 0 {
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remote = r.RemoteAddr
+		}
+
+		ip := net.ParseIP(remote)
+		if ip == nil || !d.isTrustedProxy(ip) {
+			return ""
+		}
+	}
+
+	// A chain of proxies may list every hop, the first one faced the client.
+	proto := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	return strings.ToLower(strings.TrimSpace(proto))
+}
+
+func skipsHTTPSRedirect(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/metrics" || path == "/readyz"
+}
+
+// LimitBody rejects request bodies larger than MaxBodyBytes, or MaxImportBytes
+// for /api/import. Bodies that announce their length up front are refused right
+// away, the rest are cut off while the handler reads them.
+func (d *Deps) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := d.MaxBodyBytes
+		if r.URL.Path == "/api/import" && d.MaxImportBytes > 0 {
+			limit = d.MaxImportBytes
+		}
+
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether err was returned by a http.MaxBytesReader
+// that hit its limit. The error type is not exported before Go 1.19, so the
+// message is all there is to go by.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// RequireAPIKey only lets requests through that carry the configured API key,
+// either as a bearer token or in the X-API-Key header. Without an API key
+// configured the wrapped endpoint is disabled altogether.
+func (d *Deps) RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.APIKey == "" {
+			writeProblem(w, http.StatusForbidden, "this endpoint is disabled, set API_KEY to enable it")
+			return
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(key), []byte(d.APIKey)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="raymond"`)
+			writeProblem(w, http.StatusUnauthorized, "invalid or missing API key")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// defaultContentSecurityPolicy only allows what Index needs: its inline styles
+// and script, the favicon and calls back to the API.
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityHeaders sets the usual hardening headers on every response.
+// X-Frame-Options is only sent while the policy forbids framing altogether,
+// otherwise it would override a loosened frame-ancestors in older browsers.
+func (d *Deps) SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := d.ContentSecurityPolicy
+		if policy == "" {
+			policy = defaultContentSecurityPolicy
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", policy)
+		if strings.Contains(policy, "frame-ancestors 'none'") {
+			w.Header().Set("X-Frame-Options", "DENY")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServerTime sets X-Server-Time on API responses to the time the request
+// arrived, in RFC3339, so clients can tell how far their own clock is off
+// before making sense of the timestamps in the response.
+func (d *Deps) ServerTime(next http.Handler) http.Handler {
+	if !d.ServerTimeHeader {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("X-Server-Time", d.now().UTC().Format(time.RFC3339))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Timeout answers requests that take longer than RequestTimeout with 503.
+// Requests that are meant to stay open, such as long polls and profiles,
+// are left alone.
+func (d *Deps) Timeout(next http.Handler) http.Handler {
+	if d.RequestTimeout <= 0 {
+		return next
+	}
+
+	// A problem always marshals, it only holds strings and ints.
+	body, _ := json.Marshal(newProblem(http.StatusServiceUnavailable, "request timed out"))
+	timeout := http.TimeoutHandler(next, d.RequestTimeout, string(body))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLongLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout.ServeHTTP(&timeoutProblemWriter{ResponseWriter: w}, r)
+	})
+}
+
+// timeoutProblemWriter labels the body http.TimeoutHandler sends on a timeout
+// as a problem. A handler that finishes in time has its headers copied over
+// before the status is written, so a 503 without a Content-Type can only be
+// the timeout, and any other response goes out with the headers its handler
+// set.
+type timeoutProblemWriter struct {
+	http.ResponseWriter
+}
+
+func (t *timeoutProblemWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && t.Header().Get("Content-Type") == "" {
+		t.Header().Set("Content-Type", "application/problem+json")
+	}
+
+	t.ResponseWriter.WriteHeader(status)
+}
+
+// isLongLived reports whether a request is expected to outlive any sensible
+// request timeout. Exports and the event stream are streamed, which the
+// timeout would prevent.
+func isLongLived(r *http.Request) bool {
+	if r.URL.Path == "/api/export" || r.URL.Path == "/api/events.jsonl" || r.URL.Path == "/api/stream" {
+		return true
+	}
+
+	if r.URL.Path == "/api/list" {
+		wait := r.URL.Query().Get("wait")
+		return wait != "" && wait != "0"
+	}
+
+	return strings.HasPrefix(r.URL.Path, "/debug/pprof/")
+}
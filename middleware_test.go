@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecover(t *testing.T) {
+	silenceLog(t)
+
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	})
+
+	t.Run("stack omitted when ENV is unset", func(t *testing.T) {
+		// Setenv restores the variable afterwards, it cannot unset it itself.
+		t.Setenv("ENV", "")
+		os.Unsetenv("ENV")
+
+		config := defaultConfig()
+		if err := config.LoadEnv(); err != nil {
+			t.Fatalf("loading env: %v", err)
+		}
+
+		deps := &Deps{Development: config.Env == "development"}
+
+		rec := httptest.NewRecorder()
+		deps.Recover(panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+			t.Errorf("expected a problem+json content type, got %q", got)
+		}
+
+		if got := rec.Body.String(); got != `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error"}` {
+			t.Errorf("unexpected body %s", got)
+		}
+	})
+
+	t.Run("stack included in development", func(t *testing.T) {
+		deps := &Deps{Development: true}
+
+		rec := httptest.NewRecorder()
+		deps.Recover(panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var body problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding response body: %v", err)
+		}
+
+		if !strings.Contains(body.Detail, "nil map") {
+			t.Errorf("expected the panic value as detail, got %q", body.Detail)
+		}
+
+		if !strings.Contains(body.Stack, "TestRecover") {
+			t.Errorf("expected the stack trace to point at the handler, got %q", body.Stack)
+		}
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+	})
+
+	handler := (&Deps{}).AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected the wrapped writer to implement http.Flusher")
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/list?pretty=true", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	for _, want := range []string{"GET /api/list?pretty=true 418 15B", "192.0.2.1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected log line %q to contain %q", line, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatalf("parsing trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		trustProxy     bool
+		trustedProxies []*net.IPNet
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{
+			name:         "proxy not trusted",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: "203.0.113.5",
+			want:         "10.0.0.1",
+		},
+		{
+			name:         "trust only the immediate peer",
+			trustProxy:   true,
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: "198.51.100.7, 203.0.113.5",
+			want:         "203.0.113.5",
+		},
+		{
+			name:           "skip trusted hops",
+			trustProxy:     true,
+			trustedProxies: trusted,
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   "198.51.100.7, 203.0.113.5, 192.0.2.10, 10.1.2.3",
+			want:           "203.0.113.5",
+		},
+		{
+			name:           "peer outside the trusted networks",
+			trustProxy:     true,
+			trustedProxies: trusted,
+			remoteAddr:     "203.0.113.99:1234",
+			forwardedFor:   "198.51.100.7",
+			want:           "203.0.113.99",
+		},
+		{
+			name:           "every hop is trusted",
+			trustProxy:     true,
+			trustedProxies: trusted,
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   "10.0.0.2",
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "garbage in the header",
+			trustProxy:     true,
+			trustedProxies: trusted,
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   "not-an-ip, 10.0.0.2",
+			want:           "10.0.0.2",
+		},
+		{
+			name:       "no header",
+			trustProxy: true,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &Deps{TrustProxy: tt.trustProxy, TrustedProxies: tt.trustedProxies}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			if got := deps.clientIP(req); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.MaxBodyBytes = 32
+	handler := deps.LimitBody(http.HandlerFunc(deps.Add))
+
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "within the limit", body: `{"amount":1}`, wantStatus: http.StatusOK},
+		{name: "announced length too large", body: `{"reason":"` + strings.Repeat("a", 64) + `"}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed body too large", body: `{"reason":"` + strings.Repeat("a", 64) + `"}`, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	deps := &Deps{}
+	handler := deps.SecurityHeaders(http.HandlerFunc(deps.Index))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("expected X-Content-Type-Options nosniff, got %q", got)
+	}
+
+	if got := rec.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("expected X-Frame-Options DENY, got %q", got)
+	}
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != defaultContentSecurityPolicy {
+		t.Errorf("expected the default policy, got %q", got)
+	}
+
+	deps.ContentSecurityPolicy = "default-src 'self'; frame-ancestors https://example.com"
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != deps.ContentSecurityPolicy {
+		t.Errorf("expected the configured policy, got %q", got)
+	}
+
+	if got := rec.Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("expected no X-Frame-Options once framing is allowed, got %q", got)
+	}
+}
+
+func TestServerTime(t *testing.T) {
+	deps := &Deps{ServerTimeHeader: true}
+	deps.Now = func() time.Time {
+		return time.Date(2022, time.July, 19, 15, 30, 0, 0, time.FixedZone("WIB", 7*60*60))
+	}
+	handler := deps.ServerTime(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+	if got := rec.Header().Get("X-Server-Time"); got != "2022-07-19T08:30:00Z" {
+		t.Errorf("expected the server time in UTC, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-Server-Time"); got != "" {
+		t.Errorf("expected no server time outside the API, got %q", got)
+	}
+
+	deps.ServerTimeHeader = false
+	rec = httptest.NewRecorder()
+	deps.ServerTime(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+	if got := rec.Header().Get("X-Server-Time"); got != "" {
+		t.Errorf("expected no server time once disabled, got %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	deps := &Deps{RequestTimeout: time.Millisecond * 50}
+	handler := deps.Timeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("too late"))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/rate", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	if got := rec.Body.String(); got != `{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"request timed out"}` {
+		t.Errorf("unexpected body %s", got)
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("expected a problem content type, got %q", got)
+	}
+
+	// A response in time that sets no Content-Type must not get the one of
+	// the timeout.
+	notModified := deps.Timeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	rec = httptest.NewRecorder()
+	notModified.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/list", nil))
+	if got := rec.Header().Get("Content-Type"); got != "" {
+		t.Errorf("expected no content type on a 304, got %q", got)
+	}
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/list?wait=30", nil))
+		done <- rec.Code
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected a long poll to outlive the request timeout")
+	case <-time.After(time.Millisecond * 150):
+	}
+
+	release <- struct{}{}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected the long poll to finish with %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestForceHTTPS(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parsing trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		trustProxy     bool
+		trustedProxies []*net.IPNet
+		target         string
+		remoteAddr     string
+		forwardedProto string
+		wantLocation   string
+	}{
+		{name: "plain http behind the proxy", trustProxy: true, target: "/?name=sorry", forwardedProto: "http", wantLocation: "https://example.com/?name=sorry"},
+		{name: "already https", trustProxy: true, target: "/", forwardedProto: "https"},
+		{name: "proxy not trusted", target: "/", forwardedProto: "http"},
+		{name: "no header", trustProxy: true, target: "/"},
+		{name: "api is left alone", trustProxy: true, target: "/api/list", forwardedProto: "http"},
+		{name: "metrics are left alone", trustProxy: true, target: "/metrics", forwardedProto: "http"},
+		{name: "peer in the trusted networks", trustProxy: true, trustedProxies: trusted, target: "/", remoteAddr: "10.0.0.1:1234", forwardedProto: "HTTP", wantLocation: "https://example.com/"},
+		{name: "peer outside the trusted networks", trustProxy: true, trustedProxies: trusted, target: "/", remoteAddr: "203.0.113.1:1234", forwardedProto: "http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &Deps{ForceHTTPSRedirect: true, TrustProxy: tt.trustProxy, TrustedProxies: tt.trustedProxies}
+			handler := deps.ForceHTTPS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.target, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantLocation == "" {
+				if rec.Code != http.StatusNoContent {
+					t.Errorf("expected the request to pass through, got status %d", rec.Code)
+				}
+				return
+			}
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("expected status %d, got %d", http.StatusPermanentRedirect, rec.Code)
+			}
+
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("expected a redirect to %q, got %q", tt.wantLocation, got)
+			}
+		})
+	}
+}
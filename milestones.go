@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Milestone is a note attached to a counter at a given count.
+type Milestone struct {
+	ID        int64     `json:"id"`
+	Count     int       `json:"count"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MilestoneRequest is the JSON body accepted by AddMilestone.
+type MilestoneRequest struct {
+	Count *int   `json:"count"`
+	Note  string `json:"note"`
+}
+
+// Milestones serves /api/milestones, listing on GET and recording on POST.
+// Recording needs the API key, listing is public.
+func (d *Deps) Milestones(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		d.ListMilestones(w, r)
+	case http.MethodPost:
+		d.RequireAPIKey(d.AddMilestone)(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// AddMilestone records a note at a count of the counter picked by ?name=.
+func (d *Deps) AddMilestone(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeValidationErrors(w, map[string]string{"name": counterNameRule})
+		return
+	}
+
+	var request MilestoneRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		if isBodyTooLarge(err) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		writeValidationErrors(w, decodeErrors(err))
+		return
+	}
+
+	note := strings.TrimSpace(request.Note)
+	fieldErrors := map[string]string{}
+
+	if request.Count == nil || *request.Count < 1 {
+		fieldErrors["count"] = "must be positive"
+	}
+
+	switch {
+	case note == "":
+		fieldErrors["note"] = "must not be empty"
+	case len(note) > maxReasonLength:
+		fieldErrors["note"] = "must be at most " + strconv.Itoa(maxReasonLength) + " characters"
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	milestone, err := d.insertMilestone(ctx, name, *request.Count, note)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusCreated, milestone)
+}
+
+func (d *Deps) insertMilestone(ctx context.Context, name string, count int, note string) (Milestone, error) {
+	milestone := Milestone{Count: count, Note: note, CreatedAt: d.now()}
+
+	result, err := d.DB.ExecContext(
+		ctx,
+		`INSERT INTO milestones (name, count, note, created_at) VALUES (?, ?, ?, ?)`,
+		name,
+		milestone.Count,
+		milestone.Note,
+		milestone.CreatedAt,
+	)
+	if err != nil {
+		return Milestone{}, err
+	}
+
+	milestone.ID, err = result.LastInsertId()
+	if err != nil {
+		return Milestone{}, err
+	}
+
+	return milestone, nil
+}
+
+// autoMilestone records a milestone when an increment took the total of a
+// counter from before to after across a multiple of MilestoneEvery. Only the
+// highest multiple is recorded when a large amount crosses several. Failing
+// to record it does not fail the increment, it is only logged.
+func (d *Deps) autoMilestone(ctx context.Context, name string, before int, after int) {
+	every := d.MilestoneEvery
+	if after/every <= before/every {
+		return
+	}
+
+	count := after / every * every
+	if _, err := d.insertMilestone(ctx, name, count, "Reached "+strconv.Itoa(count)); err != nil {
+		log.Printf("Recording the milestone %d of %s: %v", count, name, err)
+	}
+}
+
+// ListMilestones returns the milestones of the counter picked by ?name=,
+// ordered by count.
+func (d *Deps) ListMilestones(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT id, count, note, created_at FROM milestones WHERE name = ? ORDER BY count ASC, id ASC`,
+		name,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	milestones := []Milestone{}
+	for rows.Next() {
+		var milestone Milestone
+		if err := rows.Scan(&milestone.ID, &milestone.Count, &milestone.Note, &milestone.CreatedAt); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		milestones = append(milestones, milestone)
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, milestones)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func listMilestones(t *testing.T, deps *Deps) []Milestone {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	deps.Milestones(rec, httptest.NewRequest(http.MethodGet, "/api/milestones", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var milestones []Milestone
+	if err := json.Unmarshal(rec.Body.Bytes(), &milestones); err != nil {
+		t.Fatalf("list: decoding response body: %v", err)
+	}
+
+	return milestones
+}
+
+func TestMilestones(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+
+	if got := listMilestones(t, deps); len(got) != 0 {
+		t.Fatalf("expected no milestones, got %v", got)
+	}
+
+	post := func(body string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/milestones", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+
+		rec := httptest.NewRecorder()
+		deps.Milestones(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"count":50,"note":"halfway"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d without the API key, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	rec := post(`{"count":100,"note":"100th sorry!"}`, "secret")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	rec = post(`{"count":0,"note":" "}`, "secret")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	for _, field := range []string{`"count":"must be positive"`, `"note":"must not be empty"`} {
+		if !strings.Contains(rec.Body.String(), field) {
+			t.Errorf("expected the error %s, got %s", field, rec.Body.String())
+		}
+	}
+
+	milestones := listMilestones(t, deps)
+	if len(milestones) != 1 || milestones[0].Count != 100 || milestones[0].Note != "100th sorry!" {
+		t.Errorf("unexpected milestones %+v", milestones)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Milestones(rec, httptest.NewRequest(http.MethodDelete, "/api/milestones", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestAutoMilestone(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.MilestoneEvery = 3
+
+	doAdd(t, deps)
+	doAdd(t, deps)
+	if got := listMilestones(t, deps); len(got) != 0 {
+		t.Fatalf("expected no milestone below the threshold, got %+v", got)
+	}
+
+	doAdd(t, deps)
+
+	// 3 to 10 crosses 6 and 9, only the highest is recorded.
+	rec := httptest.NewRecorder()
+	deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"amount":7}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	milestones := listMilestones(t, deps)
+	if len(milestones) != 2 || milestones[0].Count != 3 || milestones[1].Count != 9 {
+		t.Fatalf("expected milestones at 3 and 9, got %+v", milestones)
+	}
+
+	if milestones[1].Note != "Reached 9" {
+		t.Errorf("unexpected note %q", milestones[1].Note)
+	}
+}
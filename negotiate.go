@@ -0,0 +1,65 @@
+package main
+
+import (
+	"html"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefersHTML reports whether the Accept header ranks text/html above JSON.
+// Wildcards count towards JSON, so clients sending */* or no Accept header
+// at all keep getting what they always got.
+func prefersHTML(r *http.Request) bool {
+	htmlQuality, jsonQuality := 0.0, 0.0
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "text/html":
+			if quality > htmlQuality {
+				htmlQuality = quality
+			}
+		case "application/json", "application/*", "*/*":
+			if quality > jsonQuality {
+				jsonQuality = quality
+			}
+		}
+	}
+
+	return htmlQuality > jsonQuality
+}
+
+// listHTML renders the count as a tiny page. The default security headers
+// forbid framing it, CONTENT_SECURITY_POLICY has to allow the embedding site
+// in frame-ancestors before it can be shown in an iframe elsewhere.
+func listHTML(name string, counts int, lastDate time.Time) []byte {
+	last := "never"
+	if !lastDate.IsZero() {
+		last = `<time datetime="` + lastDate.Format(time.RFC3339) + `">` + lastDate.Format("2 Jan 2006 15:04 MST") + `</time>`
+	}
+
+	return []byte(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>` + html.EscapeString(name) + `</title></head>
+<body>
+<p class="counter"><strong>` + strconv.Itoa(counts) + `</strong> ` + html.EscapeString(name) + `</p>
+<p class="last-updated">Last updated: ` + last + `</p>
+</body>
+</html>
+`)
+}
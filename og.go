@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ogImageWidth  = 1200
+	ogImageHeight = 630
+)
+
+// digitGlyphs is a 5x7 bitmap font for the digits, enough to draw a count
+// without pulling in a font renderer.
+var digitGlyphs = [10][7]string{
+	{"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	{"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	{"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	{"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	{"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	{"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	{"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	{"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	{"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	{"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+}
+
+// minusGlyph is the sign of a negative count, which adjustments and deleted
+// events can leave behind.
+var minusGlyph = [7]string{"00000", "00000", "00000", "11111", "00000", "00000", "00000"}
+
+// ogImageCache keeps the latest rendered image of every counter, an image
+// only has to be drawn again once the count changes. Its zero value is ready
+// to use.
+type ogImageCache struct {
+	mu      sync.Mutex
+	entries map[string]ogImageEntry
+}
+
+type ogImageEntry struct {
+	count int
+	png   []byte
+}
+
+func (c *ogImageCache) get(name string, count int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[name]
+	if !ok || entry.count != count {
+		return nil, false
+	}
+
+	return entry.png, true
+}
+
+func (c *ogImageCache) set(name string, count int, png []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]ogImageEntry)
+	}
+
+	c.entries[name] = ogImageEntry{count: count, png: png}
+}
+
+// renderOGImage draws count in large digits onto a 1200x630 card in the
+// colours of the page.
+func renderOGImage(count int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, ogImageWidth, ogImageHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{0xf9, 0xf9, 0xf9, 0xff}}, image.Point{}, draw.Src)
+
+	accent := &image.Uniform{C: color.RGBA{0x1d, 0x74, 0x84, 0xff}}
+	draw.Draw(img, image.Rect(0, ogImageHeight-30, ogImageWidth, ogImageHeight), accent, image.Point{}, draw.Src)
+
+	digits := strconv.Itoa(count)
+
+	// Every glyph is 5 cells wide with a cell of spacing, scaled to fill
+	// the card with a margin.
+	columns := len(digits)*6 - 1
+	scale := (ogImageWidth - 200) / columns
+	if scale > 60 {
+		scale = 60
+	}
+
+	left := (ogImageWidth - columns*scale) / 2
+	top := (ogImageHeight - 30 - 7*scale) / 2
+
+	for i, digit := range digits {
+		glyph := minusGlyph
+		if digit != '-' {
+			glyph = digitGlyphs[digit-'0']
+		}
+		for row, line := range glyph {
+			for column, cell := range line {
+				if cell != '1' {
+					continue
+				}
+
+				x := left + (i*6+column)*scale
+				y := top + row*scale
+				draw.Draw(img, image.Rect(x, y, x+scale, y+scale), accent, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// OGImage serves the current count of the counter picked by ?name= as a PNG
+// for social previews, see the og:image tag on the page.
+func (d *Deps) OGImage(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	counts, _, err := d.latestAggregate(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	body, ok := d.ogImages.get(name, counts)
+	if !ok {
+		body, err = renderOGImage(counts)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		d.ogImages.set(name, counts, body)
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(body)
+}
+
+// absoluteURL turns path into an absolute URL on the host the request was
+// made to, as crawlers need for og:image.
+func (d *Deps) absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || (d.TrustProxy && d.forwardedProto(r) == "https") {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + path
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOGImage(t *testing.T) {
+	deps := newTestDeps(t)
+
+	for i := 0; i < 7; i++ {
+		doAdd(t, deps)
+	}
+	createAggregate(t, deps)
+
+	rec := httptest.NewRecorder()
+	deps.OGImage(rec, httptest.NewRequest(http.MethodGet, "/og-image.png", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("expected a PNG content type, got %q", got)
+	}
+
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding image: %v", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != ogImageWidth || bounds.Dy() != ogImageHeight {
+		t.Errorf("expected a %dx%d image, got %v", ogImageWidth, ogImageHeight, bounds)
+	}
+
+	cached, ok := deps.ogImages.get("sorry", 7)
+	if !ok || !bytes.Equal(cached, rec.Body.Bytes()) {
+		t.Error("expected the image to be cached for the current count")
+	}
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	rec2 := httptest.NewRecorder()
+	deps.OGImage(rec2, httptest.NewRequest(http.MethodGet, "/og-image.png", nil))
+	if bytes.Equal(rec.Body.Bytes(), rec2.Body.Bytes()) {
+		t.Error("expected a new image once the count changed")
+	}
+}
+
+func TestRenderOGImageNegative(t *testing.T) {
+	negative, err := renderOGImage(-12)
+	if err != nil {
+		t.Fatalf("rendering a negative count: %v", err)
+	}
+
+	if _, err := png.Decode(bytes.NewReader(negative)); err != nil {
+		t.Fatalf("decoding image: %v", err)
+	}
+
+	positive, err := renderOGImage(12)
+	if err != nil {
+		t.Fatalf("rendering a positive count: %v", err)
+	}
+
+	if bytes.Equal(negative, positive) {
+		t.Error("expected the sign to be drawn")
+	}
+}
+
+func TestIndexOGTags(t *testing.T) {
+	deps := newTestDeps(t)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "example.com"
+	deps.Index(rec, req)
+
+	want := `<meta property="og:image" content="http://example.com/og-image.png">`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("expected the page to contain %q", want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// pausedSetting is the key in the settings table that holds whether counting
+// is paused.
+const pausedSetting = "paused"
+
+// IsPaused reports whether Add currently rejects new events.
+func (d *Deps) IsPaused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+// LoadSettings reads the settings persisted by an earlier run. It is called
+// once on startup, after the migration.
+func (d *Deps) LoadSettings(ctx context.Context) error {
+	var value string
+	err := d.DB.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, pausedSetting).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	paused, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.New("invalid paused setting: " + strconv.Quote(value))
+	}
+
+	d.setPaused(paused)
+	return nil
+}
+
+// SetPaused pauses or resumes counting and stores the state so it survives a
+// restart. Other instances on the same database pick it up when they start.
+func (d *Deps) SetPaused(ctx context.Context, paused bool) error {
+	_, err := d.DB.ExecContext(
+		ctx,
+		`INSERT INTO settings (key, value) VALUES (?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		pausedSetting,
+		strconv.FormatBool(paused),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.setPaused(paused)
+	return nil
+}
+
+func (d *Deps) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&d.paused, v) == v {
+		return
+	}
+
+	if paused {
+		log.Println("Counting paused")
+	} else {
+		log.Println("Counting resumed")
+	}
+}
+
+// Pause stops Add from recording events until Resume is called. Everything
+// else, reads included, keeps working.
+func (d *Deps) Pause(w http.ResponseWriter, r *http.Request) {
+	d.togglePause(w, r, true)
+}
+
+// Resume lets Add record events again after Pause.
+func (d *Deps) Resume(w http.ResponseWriter, r *http.Request) {
+	d.togglePause(w, r, false)
+}
+
+func (d *Deps) togglePause(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	if err := d.SetPaused(ctx, paused); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{"paused": d.IsPaused()})
+}
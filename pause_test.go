@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPause(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+	silenceLog(t)
+
+	server := httptest.NewServer(deps.Handler(deps.Routes()))
+	defer server.Close()
+	baseURL := server.URL
+
+	toggle := func(path string) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+path, nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("requesting %s: %v", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	toggle("/api/pause")
+
+	resp, err := http.Post(baseURL+"/api/add", "application/json", nil)
+	if err != nil {
+		t.Fatalf("adding: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d while paused, got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	resp, err = http.Get(baseURL + "/api/list")
+	if err != nil {
+		t.Fatalf("listing: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected reads to keep working, got status %d", resp.StatusCode)
+	}
+
+	// A fresh instance on the same database starts paused.
+	restarted := &Deps{DB: deps.DB}
+	if err := restarted.LoadSettings(context.Background()); err != nil {
+		t.Fatalf("loading settings: %v", err)
+	}
+
+	if !restarted.IsPaused() {
+		t.Error("expected the paused state to be persisted")
+	}
+
+	toggle("/api/resume")
+
+	resp, err = http.Post(baseURL+"/api/add", "application/json", nil)
+	if err != nil {
+		t.Fatalf("adding: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected adding to work again, got status %d", resp.StatusCode)
+	}
+}
+
+func TestPauseRequiresAPIKey(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.APIKey = "secret"
+
+	rec := httptest.NewRecorder()
+	deps.Handler(deps.Routes()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/pause", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if deps.IsPaused() {
+		t.Error("expected counting not to be paused")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problem is an RFC 7807 problem details object. Type stays about:blank,
+// the status code already says what kind of problem it is.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors is an extension member listing invalid fields by name.
+	Errors map[string]string `json:"errors,omitempty"`
+	// Stack is an extension member with the stack trace of a panic, only
+	// set in development.
+	Stack string `json:"stack,omitempty"`
+	// RetryAfter is an extension member with the seconds a client has to
+	// wait before trying again.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func newProblem(status int, detail string) problem {
+	return problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (p problem) write(w http.ResponseWriter) {
+	responseBody, err := json.Marshal(p)
+	if err != nil {
+		// Marshalling a problem cannot fail, it only holds strings and ints.
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(responseBody)
+}
+
+// writeProblem answers the request with an application/problem+json body.
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	newProblem(status, detail).write(w)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// reportDays is how many days the chart of Report covers, today included.
+const reportDays = 30
+
+// Size of the chart of Report, in pixels.
+const (
+	reportChartWidth  = 600
+	reportChartHeight = 120
+)
+
+// reportTemplate is self-contained: the styles are inline and the chart is an
+// inline SVG, so the page can be saved, printed or mailed as it is.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Report: {{.Name}}</title>
+<style>
+	body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; color: #4a4a4a; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+	h1 { margin-bottom: 0; }
+	.generated { color: #888; margin-top: 0.2em; }
+	table { border-collapse: collapse; width: 100%; margin: 1.5em 0; }
+	th, td { text-align: left; padding: 0.4em; border-bottom: 1px solid #f1f1f1; }
+	td { text-align: right; }
+	svg { display: block; margin: 1em 0; }
+	rect { fill: #1d7484; }
+	@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p class="generated">Generated {{.Generated}}</p>
+<table>
+	<tr><th>Total</th><td>{{.Total}}</td></tr>
+	<tr><th>First event</th><td>{{.First}}</td></tr>
+	<tr><th>Latest event</th><td>{{.Last}}</td></tr>
+	<tr><th>This week</th><td>{{.Trend.Current}}</td></tr>
+	<tr><th>Last week</th><td>{{.Trend.Previous}}</td></tr>
+	<tr><th>Change</th><td>{{.Change}}</td></tr>
+	<tr><th>Current streak</th><td>{{.CurrentStreak}} days</td></tr>
+	<tr><th>Longest streak</th><td>{{.LongestStreak}} days</td></tr>
+</table>
+<h2>Last {{len .Days}} days</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.ChartWidth}}" height="{{.ChartHeight}}" viewBox="0 0 {{.ChartWidth}} {{.ChartHeight}}" role="img" aria-label="Events per day">
+{{- range .Days}}
+	<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Label}}: {{.Count}}</title></rect>
+{{- end}}
+</svg>
+</body>
+</html>
+`))
+
+// reportData is what reportTemplate is executed with.
+type reportData struct {
+	Name      string
+	Generated string
+	Total     int
+	First     string
+	Last      string
+	Trend     trend
+	// Change is Trend.Change as a percentage, n/a without a previous week.
+	Change        string
+	CurrentStreak int
+	LongestStreak int
+	ChartWidth    int
+	ChartHeight   int
+	Days          []reportBar
+}
+
+// reportBar is a single day in the chart of Report.
+type reportBar struct {
+	X, Y, Width, Height int
+	Label               string
+	Count               int
+}
+
+// Report renders a summary of the counter picked by ?name= as a static HTML
+// page: the total, this week against the last, the streaks and a chart of the
+// events per day. It needs neither scripts nor further requests.
+func (d *Deps) Report(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	data, err := d.reportData(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Rendering into a buffer first keeps a failing template from leaving a
+	// half written page behind a 200.
+	var page bytes.Buffer
+	if err := reportTemplate.Execute(&page, data); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="`+name+`-report.html"`)
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(page.Bytes())
+}
+
+func (d *Deps) reportData(ctx context.Context, name string) (reportData, error) {
+	now := d.now().In(d.location())
+	data := reportData{
+		Name:        name,
+		Generated:   now.Format("2 Jan 2006 15:04 MST"),
+		First:       "never",
+		Last:        "never",
+		ChartWidth:  reportChartWidth,
+		ChartHeight: reportChartHeight,
+	}
+
+	c, err := d.readDB().Conn(ctx)
+	if err != nil {
+		return reportData{}, err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	data.Total, err = liveTotal(ctx, c, name)
+	if err != nil {
+		return reportData{}, err
+	}
+
+	_, first, last, err := eventSpan(ctx, c, name)
+	if err != nil {
+		return reportData{}, err
+	}
+
+	if !first.IsZero() {
+		data.First = first.In(d.location()).Format("2 Jan 2006 15:04 MST")
+		data.Last = last.In(d.location()).Format("2 Jan 2006 15:04 MST")
+	}
+
+	data.Trend, err = d.periodTrend(ctx, name, "week")
+	if err != nil {
+		return reportData{}, err
+	}
+
+	data.Change = "n/a"
+	if data.Trend.Change != nil {
+		data.Change = strconv.FormatFloat(*data.Trend.Change, 'f', 1, 64) + "%"
+		if *data.Trend.Change > 0 {
+			data.Change = "+" + data.Change
+		}
+	}
+
+	data.CurrentStreak, data.LongestStreak, err = d.streaks(ctx, name)
+	if err != nil {
+		return reportData{}, err
+	}
+
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1-reportDays)
+	days, err := d.series(ctx, name, from, now, time.Hour*24)
+	if err != nil {
+		return reportData{}, err
+	}
+
+	highest := 1
+	for _, day := range days {
+		if day.Count > highest {
+			highest = day.Count
+		}
+	}
+
+	slot := reportChartWidth / len(days)
+	for i, day := range days {
+		// Adjustments are the only negative counts and they are left out of
+		// the series, a day below zero would still get no bar.
+		height := day.Count * reportChartHeight / highest
+		if height < 0 {
+			height = 0
+		}
+
+		data.Days = append(data.Days, reportBar{
+			X:      i * slot,
+			Y:      reportChartHeight - height,
+			Width:  slot - 2,
+			Height: height,
+			Label:  day.BucketStart.Format("2 Jan"),
+			Count:  day.Count,
+		})
+	}
+
+	return data, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	deps := newTestDeps(t)
+	now := time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)
+	deps.Now = func() time.Time { return now }
+
+	// Two days in a row this week, one event the week before.
+	addAt(t, deps, "2024-03-06T09:00:00Z")
+	addAt(t, deps, "2024-03-13T09:00:00Z")
+	addAt(t, deps, "2024-03-14T09:00:00Z")
+	addAt(t, deps, "2024-03-14T10:00:00Z")
+
+	rec := httptest.NewRecorder()
+	deps.Report(rec, httptest.NewRequest(http.MethodGet, "/api/report.html", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("expected an HTML page, got %q", got)
+	}
+
+	page := rec.Body.String()
+	for _, want := range []string{
+		"<tr><th>Total</th><td>4</td></tr>",
+		"<tr><th>This week</th><td>3</td></tr>",
+		"<tr><th>Last week</th><td>1</td></tr>",
+		"<tr><th>Change</th><td>&#43;200.0%</td></tr>",
+		"<tr><th>Current streak</th><td>2 days</td></tr>",
+		"<title>14 Mar: 2</title>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+
+	if got := strings.Count(page, "<rect "); got != reportDays {
+		t.Errorf("expected %d days in the chart, got %d", reportDays, got)
+	}
+
+	if strings.Contains(page, "<script") {
+		t.Error("expected the report to work without scripts")
+	}
+}
+
+func TestReportLeavesOutAdjustments(t *testing.T) {
+	deps := newTestDeps(t)
+	now := time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)
+	deps.Now = func() time.Time { return now }
+	silenceLog(t)
+
+	addAt(t, deps, "2024-03-14T09:00:00Z")
+	addAt(t, deps, "2024-03-14T10:00:00Z")
+	addAt(t, deps, "2024-03-14T11:00:00Z")
+
+	// Setting the count below the total records an adjustment of -2 today.
+	if _, err := deps.adjustCount(context.Background(), defaultCounterName, 1); err != nil {
+		t.Fatalf("adjusting the count: %v", err)
+	}
+
+	data, err := deps.reportData(context.Background(), defaultCounterName)
+	if err != nil {
+		t.Fatalf("building the report: %v", err)
+	}
+
+	if data.Total != 1 {
+		t.Errorf("expected the total to include the adjustment, got %d", data.Total)
+	}
+
+	if data.Trend.Current != 3 {
+		t.Errorf("expected this week to count the events only, got %d", data.Trend.Current)
+	}
+
+	today := data.Days[len(data.Days)-1]
+	if today.Count != 3 {
+		t.Errorf("expected today to count the events only, got %d", today.Count)
+	}
+
+	for _, day := range data.Days {
+		if day.Height < 0 || day.Y+day.Height > reportChartHeight {
+			t.Errorf("expected every bar within the chart, got %+v", day)
+		}
+	}
+}
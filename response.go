@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wantsPretty reports whether the client asked for indented JSON, either with
+// ?pretty=true or with an indent parameter on application/json in Accept.
+func wantsPretty(r *http.Request) bool {
+	if pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty")); err == nil {
+		return pretty
+	}
+
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		parts := strings.Split(mediaRange, ";")
+		if strings.TrimSpace(parts[0]) != "application/json" {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			key := strings.SplitN(strings.TrimSpace(param), "=", 2)[0]
+			if strings.EqualFold(key, "indent") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// marshalJSON encodes v compactly, or indented when the client wants it. With
+// SnakeCaseJSON every object key is rewritten to snake_case.
+func (d *Deps) marshalJSON(r *http.Request, v interface{}) ([]byte, error) {
+	if !wantsPretty(r) {
+		return d.marshalCompactJSON(v)
+	}
+
+	if d.SnakeCaseJSON {
+		var err error
+		v, err = snakeCaseKeys(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// marshalCompactJSON is marshalJSON for formats that need every value on a
+// single line, whatever the client asked for.
+func (d *Deps) marshalCompactJSON(v interface{}) ([]byte, error) {
+	if d.SnakeCaseJSON {
+		var err error
+		v, err = snakeCaseKeys(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// writeJSON answers the request with v as an application/json body.
+func (d *Deps) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	responseBody, err := d.marshalJSON(r, v)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseBody)
+}
+
+// snakeCaseKeys round-trips v through its JSON form, renaming the keys of
+// every object on the way. Responses are built from maps and structs alike,
+// so the keys are only known once encoded.
+func snakeCaseKeys(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	return renameKeys(decoded), nil
+}
+
+func renameKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			renamed[snakeCase(key)] = renameKeys(value)
+		}
+		return renamed
+	case []interface{}:
+		for i, value := range v {
+			v[i] = renameKeys(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// snakeCase turns a camelCase key such as lastDate into last_date.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
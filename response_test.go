@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSONPretty(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   string
+	}{
+		{name: "compact by default", target: "/api/version", want: `{"ok":true}`},
+		{name: "pretty query", target: "/api/version?pretty=true", want: "{\n  \"ok\": true\n}"},
+		{name: "pretty disabled", target: "/api/version?pretty=false", accept: "application/json; indent", want: `{"ok":true}`},
+		{name: "indent parameter", target: "/api/version", accept: "text/html;q=0.5, application/json; indent", want: "{\n  \"ok\": true\n}"},
+		{name: "parameter on another type", target: "/api/version", accept: "text/plain; indent", want: `{"ok":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			(&Deps{}).writeJSON(rec, req, http.StatusOK, map[string]bool{"ok": true})
+
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("expected application/json, got %q", got)
+			}
+		})
+	}
+}
+
+func TestSnakeCaseJSON(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.SnakeCaseJSON = true
+
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+	createAggregate(t, deps)
+
+	body := doList(t, deps)
+	if _, ok := body["last_date"]; !ok {
+		t.Errorf("expected a last_date key, got %v", body)
+	}
+
+	if _, ok := body["lastDate"]; ok {
+		t.Errorf("expected no lastDate key, got %v", body)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.History(rec, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+	if got := rec.Body.String(); got != `[{"count":1,"created_at":"2022-07-19T08:00:00Z","id":1}]` {
+		t.Errorf("expected nested keys to be renamed, got %s", got)
+	}
+}
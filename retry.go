@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isRetryable reports whether err is a lock or serialization failure that may
+// go away when the transaction is simply run again.
+func isRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	// Postgres drivers expose the SQLSTATE through this method, 40001 is a
+	// serialization failure and 40P01 a detected deadlock.
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		state := stateErr.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+
+	return false
+}
+
+// txOptions are what every transaction is begun with. The isolation level
+// defaults to serializable, the only one SQLite has anyway.
+func (d *Deps) txOptions() *sql.TxOptions {
+	isolation := d.Isolation
+	if isolation == sql.LevelDefault {
+		isolation = sql.LevelSerializable
+	}
+
+	return &sql.TxOptions{Isolation: isolation, ReadOnly: false}
+}
+
+// retryTx runs fn, which is expected to run a whole transaction, until it
+// succeeds or fails with an error that is not retryable. Attempts are spaced
+// with an exponential backoff and capped at TxMaxAttempts.
+func (d *Deps) retryTx(ctx context.Context, fn func() error) error {
+	backoff := time.Millisecond * 10
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= d.TxMaxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		log.Printf("transaction attempt %d failed, retrying in %s: %v", attempt, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
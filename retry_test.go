@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestRetryTx(t *testing.T) {
+	busy := fmt.Errorf("inserting: %w", sqlite3.Error{Code: sqlite3.ErrBusy})
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "succeeds right away", errs: []error{nil}, wantAttempts: 1},
+		{name: "retries while busy", errs: []error{busy, busy, nil}, wantAttempts: 3},
+		{name: "retries serialization failures", errs: []error{sqlStateError("40001"), nil}, wantAttempts: 2},
+		{name: "gives up after max attempts", errs: []error{busy, busy, busy, busy}, wantAttempts: 3, wantErr: true},
+		{name: "does not retry other errors", errs: []error{errors.New("constraint failed"), nil}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &Deps{TxMaxAttempts: 3}
+			silenceLog(t)
+
+			attempts := 0
+			err := deps.retryTx(context.Background(), func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTxOptions(t *testing.T) {
+	deps := &Deps{}
+	if got := deps.txOptions().Isolation; got != sql.LevelSerializable {
+		t.Errorf("expected serializable by default, got %s", got)
+	}
+
+	deps.Isolation = sql.LevelReadCommitted
+	if got := deps.txOptions().Isolation; got != sql.LevelReadCommitted {
+		t.Errorf("expected the configured level, got %s", got)
+	}
+}
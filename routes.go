@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// Routes registers every endpoint on a new mux, the admin ones included. This
+// is what is served when there is no separate admin listener.
+func (d *Deps) Routes() *http.ServeMux {
+	mux := d.PublicRoutes()
+	d.registerAdminRoutes(mux)
+
+	return mux
+}
+
+// AdminRoutes registers the endpoints meant for operators only on a new mux,
+// for a listener that is not exposed publicly.
+func (d *Deps) AdminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	d.registerAdminRoutes(mux)
+
+	return mux
+}
+
+func (d *Deps) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/recompute", d.Recompute)
+	mux.HandleFunc("/metrics", d.Metrics)
+
+	// Profiles expose a lot about the process, they are opt-in.
+	if d.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+}
+
+// PublicRoutes registers every endpoint except the admin ones on a new mux.
+func (d *Deps) PublicRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/list", d.List)
+	mux.HandleFunc("/api/stream", d.Stream)
+	mux.HandleFunc("/api/add", d.Add)
+	mux.HandleFunc("/api/subtract", d.Subtract)
+	mux.HandleFunc("/api/undo", d.Undo)
+	mux.HandleFunc("/api/redo", d.Redo)
+	mux.HandleFunc("/api/count", d.Count)
+	mux.HandleFunc("/api/since", d.Since)
+	mux.HandleFunc("/api/history", d.History)
+	mux.HandleFunc("/api/export", d.Export)
+	mux.HandleFunc("/api/events.jsonl", d.EventsJSONLines)
+	mux.HandleFunc("/api/report.html", d.Report)
+	mux.HandleFunc("/api/badge.svg", d.Badge)
+	mux.HandleFunc("/api/milestones", d.Milestones)
+	mux.HandleFunc("/api/leaderboard", d.Leaderboard)
+	mux.HandleFunc("/api/import", d.RequireAPIKey(d.Import))
+	mux.HandleFunc("/api/events/", d.RequireAPIKey(d.DeleteEvent))
+	mux.HandleFunc("/api/events/nth/", d.NthEvent)
+	mux.HandleFunc("/api/maintenance", d.RequireAPIKey(d.MaintenanceToggle))
+	mux.HandleFunc("/api/pause", d.RequireAPIKey(d.Pause))
+	mux.HandleFunc("/api/resume", d.RequireAPIKey(d.Resume))
+	mux.HandleFunc("/api/version", d.Version)
+	mux.HandleFunc("/readyz", d.Ready)
+	mux.HandleFunc("/api/subjects", d.ListSubjects)
+	mux.HandleFunc("/api/stats/hourly", d.HourlyHistogram)
+	mux.HandleFunc("/api/stats/weekday", d.Weekday)
+	mux.HandleFunc("/api/stats/rate", d.Rate)
+	mux.HandleFunc("/api/stats/streak", d.Streak)
+	mux.HandleFunc("/api/stats/monthly", d.Monthly)
+	mux.HandleFunc("/api/stats/series", d.Series)
+	mux.HandleFunc("/api/stats/compare", d.Compare)
+	mux.HandleFunc("/api/stats/requests", d.RequestStats)
+	static := staticHandler()
+	mux.Handle("/favicon.ico", static)
+	mux.HandleFunc("/og-image.png", d.OGImage)
+	mux.Handle("/static/", http.StripPrefix("/static", static))
+	mux.HandleFunc("/", d.Index)
+
+	return mux
+}
+
+// Handler wraps mux in the middleware every request goes through.
+func (d *Deps) Handler(mux *http.ServeMux) http.Handler {
+	return d.AccessLog(d.CountRequests(mux, d.Recover(d.SecurityHeaders(d.ServerTime(d.ForceHTTPS(d.Visitors(d.LimitBody(d.Timeout(d.Maintenance(mux))))))))))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.EnablePprof = true
+
+	tests := []struct {
+		path   string
+		public int
+		admin  int
+	}{
+		{path: "/metrics", public: http.StatusNotFound, admin: http.StatusOK},
+		{path: "/debug/pprof/", public: http.StatusNotFound, admin: http.StatusOK},
+		{path: "/api/recompute", public: http.StatusNotFound, admin: http.StatusMethodNotAllowed},
+		{path: "/api/list", public: http.StatusOK, admin: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		for _, mux := range []struct {
+			name string
+			mux  *http.ServeMux
+			want int
+		}{
+			{name: "public", mux: deps.PublicRoutes(), want: tt.public},
+			{name: "admin", mux: deps.AdminRoutes(), want: tt.admin},
+		} {
+			rec := httptest.NewRecorder()
+			mux.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != mux.want {
+				t.Errorf("expected status %d for %s on the %s mux, got %d", mux.want, tt.path, mux.name, rec.Code)
+			}
+		}
+
+		// Without a separate listener everything is served together.
+		rec := httptest.NewRecorder()
+		deps.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code == http.StatusNotFound {
+			t.Errorf("expected %s to be served by the combined mux", tt.path)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// Seed starts an empty database at count, as a single adjustment of the
+// default counter with its aggregate, so the statistics still start empty. It is called once on startup, after the
+// migration. Any row in the counter table, deleted or not, means the database
+// is in use and is left alone, so restarts don't seed it again.
+func (d *Deps) Seed(ctx context.Context, count int) error {
+	var seeded bool
+	err := d.retryTx(ctx, func() error {
+		var err error
+		seeded, err = d.insertSeed(ctx, count)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !seeded {
+		return nil
+	}
+
+	if err := d.CreateAggregate(ctx, defaultCounterName); err != nil {
+		return err
+	}
+
+	log.Printf("Seeded empty database with counts: %d", count)
+
+	return nil
+}
+
+func (d *Deps) insertSeed(ctx context.Context, count int) (bool, error) {
+	c, err := d.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return false, err
+	}
+
+	var used bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM counter)`).Scan(&used)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return false, e
+		}
+
+		return false, err
+	}
+
+	if used {
+		return false, tx.Rollback()
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO counter (name, count, created_at, reason, adjustment) VALUES (?, ?, ?, ?, 1)`,
+		defaultCounterName,
+		count,
+		d.now(),
+		"seed",
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return false, e
+		}
+
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	if err := deps.Seed(context.Background(), 42); err != nil {
+		t.Fatalf("seeding: %v", err)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(42) {
+		t.Errorf("expected counter to be 42, got %v", body["counter"])
+	}
+
+	var adjustments int
+	if err := deps.DB.QueryRow(`SELECT COUNT(*) FROM counter WHERE adjustment = 1`).Scan(&adjustments); err != nil {
+		t.Fatalf("counting adjustments: %v", err)
+	}
+
+	if adjustments != 1 {
+		t.Errorf("expected the seed to be recorded as an adjustment, got %d", adjustments)
+	}
+
+	// A restart must not seed again.
+	if err := deps.Seed(context.Background(), 42); err != nil {
+		t.Fatalf("seeding again: %v", err)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(42) {
+		t.Errorf("expected counter to stay 42, got %v", body["counter"])
+	}
+}
+
+func TestSeedLeavesUsedDatabase(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	doAdd(t, deps)
+
+	if err := deps.Seed(context.Background(), 42); err != nil {
+		t.Fatalf("seeding: %v", err)
+	}
+
+	if body := doList(t, deps); body["counter"] != float64(1) {
+		t.Errorf("expected counter to be 1, got %v", body["counter"])
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves the embedded static directory, mounted on both
+// /favicon.ico and /static/. Requests carrying the current asset version, see
+// assetURL, can be cached for a year since a new build changes their URL. A
+// build that cannot tell itself apart from the next has its assets
+// revalidated on every use instead.
+func staticHandler() http.Handler {
+	root, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		// Only possible when the embed directive above is broken.
+		panic(err)
+	}
+
+	fileServer := http.FileServer(http.FS(root))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !assetVersionKnown():
+			w.Header().Set("Cache-Control", "no-cache")
+		case r.URL.Query().Get("v") == assetVersion():
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		default:
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStaticRoutes(t *testing.T) {
+	baseURL, cleanup := newTestServer(t)
+	defer cleanup()
+	silenceLog(t)
+
+	tests := []struct {
+		path            string
+		wantStatus      int
+		wantContentType string
+	}{
+		{path: "/", wantStatus: http.StatusOK, wantContentType: "text/html"},
+		{path: "/favicon.ico", wantStatus: http.StatusOK, wantContentType: "image/"},
+		{path: "/static/favicon.ico", wantStatus: http.StatusOK, wantContentType: "image/"},
+		{path: "/static/missing.css", wantStatus: http.StatusNotFound, wantContentType: "text/plain"},
+		{path: "/does-not-exist", wantStatus: http.StatusNotFound, wantContentType: "application/problem+json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(baseURL + tt.path)
+			if err != nil {
+				t.Fatalf("requesting %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+
+			if !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.wantContentType) {
+				t.Errorf("expected content type %q, got %q", tt.wantContentType, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// setBuild overrides the build information for the duration of a test.
+func setBuild(t *testing.T, v, c, revision string) {
+	t.Helper()
+
+	oldVersion, oldCommit, oldRevision := version, commit, vcsRevision
+	version, commit, vcsRevision = v, c, revision
+	t.Cleanup(func() {
+		version, commit, vcsRevision = oldVersion, oldCommit, oldRevision
+	})
+}
+
+func TestStaticCacheBusting(t *testing.T) {
+	setBuild(t, "dev", "unknown", "0123abc")
+	handler := staticHandler()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: assetURL("/favicon.ico"), want: "public, max-age=31536000, immutable"},
+		{url: "/favicon.ico?v=old", want: "public, max-age=86400"},
+		{url: "/favicon.ico", want: "public, max-age=86400"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if got := rec.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("expected Cache-Control %q for %s, got %q", tt.want, tt.url, got)
+		}
+	}
+}
+
+func TestStaticCacheUnknownBuild(t *testing.T) {
+	setBuild(t, "dev", "unknown", "")
+	handler := staticHandler()
+
+	if got := assetURL("/favicon.ico"); got != "/favicon.ico?v=dev" {
+		t.Fatalf("expected the dev asset version, got %s", got)
+	}
+
+	for _, url := range []string{"/favicon.ico?v=dev", "/favicon.ico"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("expected Cache-Control no-cache for %s, got %q", url, got)
+		}
+	}
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HourlyHistogram returns a 24-element array with the total count for each
+// hour of the day in the configured timezone, across the whole history. Hours
+// without any event are reported as zero.
+func (d *Deps) HourlyHistogram(w http.ResponseWriter, r *http.Request) {
+	d.histogram(w, r, 24, func(t time.Time) int {
+		return t.Hour()
+	})
+}
+
+// Weekday returns a 7-element array with the total count for each day of the
+// week in the configured timezone, starting on Sunday, across the whole
+// history. Days without any event are reported as zero.
+func (d *Deps) Weekday(w http.ResponseWriter, r *http.Request) {
+	d.histogram(w, r, 7, func(t time.Time) int {
+		return int(t.Weekday())
+	})
+}
+
+// histogram answers with the total count of the counter picked by ?name= in
+// each of n buckets, bucket maps an event time in the configured timezone to
+// its bucket.
+func (d *Deps) histogram(w http.ResponseWriter, r *http.Request, n int, bucket func(time.Time) int) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	c, err := d.readDB().Conn(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	// Bucketing happens here rather than with strftime, SQLite only knows
+	// about UTC and fixed offsets, not about named timezones.
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT count, created_at FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0`,
+		name,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	buckets := make([]int, n)
+	for rows.Next() {
+		var count int
+		var createdAt time.Time
+		if err := rows.Scan(&count, &createdAt); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		buckets[bucket(createdAt.In(d.location()))] += count
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, buckets)
+}
+
+// eventSpan returns the count of the events along with the timestamps of the
+// first and the last one. Adjustments are left out, so the count can differ
+// from liveTotal. Both timestamps are zero when there are no events.
+func eventSpan(ctx context.Context, c *sql.Conn, name string) (total int, first time.Time, last time.Time, err error) {
+	err = c.QueryRowContext(ctx, `SELECT COALESCE(SUM(count), 0) FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0`, name).Scan(&total)
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, err
+	}
+
+	err = c.QueryRowContext(ctx, `SELECT created_at FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 ORDER BY julianday(created_at) ASC LIMIT 1`, name).Scan(&first)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return total, time.Time{}, time.Time{}, nil
+		}
+
+		return 0, time.Time{}, time.Time{}, err
+	}
+
+	err = c.QueryRowContext(ctx, `SELECT created_at FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 ORDER BY julianday(created_at) DESC LIMIT 1`, name).Scan(&last)
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, err
+	}
+
+	return total, first, last, nil
+}
+
+// Rate reports the average number of sorries per day, measured over the span
+// between the first and the last event.
+func (d *Deps) Rate(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	c, err := d.readDB().Conn(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	total, first, last, err := eventSpan(ctx, c, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Anything shorter than a day, including a single event, counts as one
+	// day so the rate never divides by zero.
+	days := last.Sub(first).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"total":      total,
+		"days":       math.Round(days*100) / 100,
+		"ratePerDay": math.Round(float64(total)/days*100) / 100,
+	})
+}
+
+// CountAsOf returns the total count up to and including the asOf query
+// parameter, or the current total when it is omitted.
+func (d *Deps) CountAsOf(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	asOf := d.now()
+	if v := r.URL.Query().Get("asOf"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "asOf must be an RFC3339 timestamp")
+			return
+		}
+
+		asOf = t
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	c, err := d.readDB().Conn(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	// julianday normalizes both sides to UTC, created_at might have been
+	// written with any offset.
+	var counts int
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(count), 0) FROM counter
+			WHERE name = ? AND deleted_at IS NULL AND julianday(created_at) <= julianday(?)`,
+		name,
+		asOf,
+	).Scan(&counts)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"count": counts,
+		"asOf":  asOf.Format(time.RFC3339),
+	})
+}
+
+// Streak reports the current run of consecutive days, in the configured
+// timezone, with at least one event, along with the longest run ever. A run
+// that ended yesterday still counts as current, today isn't over yet.
+func (d *Deps) Streak(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	current, longest, err := d.streaks(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"current": current,
+		"longest": longest,
+	})
+}
+
+// streaks computes the current and the longest streak of the named counter,
+// see Streak.
+func (d *Deps) streaks(ctx context.Context, name string) (current int, longest int, err error) {
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT created_at FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 ORDER BY julianday(created_at) ASC`,
+		name,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	var days []time.Time
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return 0, 0, err
+		}
+
+		day := calendarDay(createdAt.In(d.location()))
+		if len(days) == 0 || !days[len(days)-1].Equal(day) {
+			days = append(days, day)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	current, longest = streaks(days, calendarDay(d.now().In(d.location())))
+	return current, longest, nil
+}
+
+// calendarDay drops the time of day from t, keeping the date as seen in t's
+// location. The result is in UTC so consecutive days are always 24 hours
+// apart, regardless of daylight saving time.
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// streaks computes the current and the longest run of consecutive days out of
+// distinct, ascending calendar days.
+func streaks(days []time.Time, today time.Time) (current int, longest int) {
+	run := 0
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+
+		if run > longest {
+			longest = run
+		}
+	}
+
+	if len(days) > 0 {
+		last := days[len(days)-1]
+		if last.Equal(today) || last.AddDate(0, 0, 1).Equal(today) {
+			current = run
+		}
+	}
+
+	return current, longest
+}
+
+// Since reports how long ago the latest event of a counter happened, for a
+// "days since the last incident" sign. Without any event lastDate is null
+// and secondsSince is -1.
+func (d *Deps) Since(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	var lastDate interface{}
+	secondsSince := int64(-1)
+
+	var last time.Time
+	err = d.readDB().QueryRowContext(
+		ctx,
+		`SELECT created_at FROM counter WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 ORDER BY julianday(created_at) DESC LIMIT 1`,
+		name,
+	).Scan(&last)
+	switch {
+	case err == nil:
+		lastDate = last.Format(time.RFC3339)
+		secondsSince = int64(d.now().Sub(last) / time.Second)
+		if secondsSince < 0 {
+			secondsSince = 0
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"lastDate":     lastDate,
+		"secondsSince": secondsSince,
+	})
+}
+
+// Monthly returns the total count of each of the last ?months= months,
+// oldest first and including the current one. Months are split in the
+// configured timezone and months without events are reported as zero.
+func (d *Deps) Monthly(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	months := 12
+	if v := r.URL.Query().Get("months"); v != "" {
+		months, err = strconv.Atoi(v)
+		if err != nil || months < 1 || months > 120 {
+			writeProblem(w, http.StatusBadRequest, "months must be a number between 1 and 120")
+			return
+		}
+	}
+
+	now := d.now().In(d.location())
+	start := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, d.location())
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	// Like HourlyHistogram, bucketing happens here because SQLite's strftime
+	// does not know about named timezones.
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT count, created_at FROM counter
+			WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 AND julianday(created_at) >= julianday(?)`,
+		name,
+		start,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	type monthCount struct {
+		Month string `json:"month"`
+		Count int    `json:"count"`
+	}
+
+	totals := make([]monthCount, months)
+	index := make(map[string]int, months)
+	for i := range totals {
+		month := start.AddDate(0, i, 0).Format("2006-01")
+		totals[i].Month = month
+		index[month] = i
+	}
+
+	for rows.Next() {
+		var count int
+		var createdAt time.Time
+		if err := rows.Scan(&count, &createdAt); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Events backfilled into the future of now are left out.
+		if i, ok := index[createdAt.In(d.location()).Format("2006-01")]; ok {
+			totals[i].Count += count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, totals)
+}
+
+// maxSeriesBuckets caps how many intervals Series returns.
+const maxSeriesBuckets = 1000
+
+// seriesBucket is a single interval of Series.
+type seriesBucket struct {
+	BucketStart time.Time `json:"bucketStart"`
+	Count       int       `json:"count"`
+}
+
+// Series buckets the events of the counter picked by ?name= into intervals of
+// ?interval=, e.g. 5m, 1h or 1d, from ?from= up to and including ?to=. Both are
+// RFC3339 timestamps, to defaults to now and from to 23 intervals before the
+// one to falls into. Empty intervals are included with a count of zero.
+func (d *Deps) Series(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+
+	interval := time.Hour
+	if v := query.Get("interval"); v != "" {
+		interval, err = parseInterval(v)
+		if err != nil || interval < time.Minute {
+			writeProblem(w, http.StatusBadRequest, "interval must be a duration of at least 1m, such as 5m, 1h or 1d")
+			return
+		}
+	}
+
+	to := d.now()
+	if v := query.Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "to must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	from := to.Truncate(interval).Add(-23 * interval)
+	if v := query.Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "from must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	if from.After(to) {
+		writeProblem(w, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+
+	// Dividing first keeps a range of centuries from overflowing.
+	if to.Sub(from)/interval >= maxSeriesBuckets {
+		writeProblem(w, http.StatusBadRequest, "the range must not span more than "+strconv.Itoa(maxSeriesBuckets)+" intervals")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	series, err := d.series(ctx, name, from, to, interval)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, series)
+}
+
+// series buckets the events of the named counter between from and to into
+// intervals, see Series. The caller keeps the number of buckets in check.
+func (d *Deps) series(ctx context.Context, name string, from, to time.Time, interval time.Duration) ([]seriesBucket, error) {
+	buckets := int(to.Sub(from)/interval) + 1
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT count, created_at FROM counter
+			WHERE name = ? AND deleted_at IS NULL AND adjustment = 0
+				AND julianday(created_at) >= julianday(?) AND julianday(created_at) <= julianday(?)`,
+		name,
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	series := make([]seriesBucket, buckets)
+	for i := range series {
+		series[i].BucketStart = from.Add(time.Duration(i) * interval).In(d.location())
+	}
+
+	for rows.Next() {
+		var count int
+		var createdAt time.Time
+		if err := rows.Scan(&count, &createdAt); err != nil {
+			return nil, err
+		}
+
+		if i := int(createdAt.Sub(from) / interval); i >= 0 && i < buckets {
+			series[i].Count += count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// parseInterval reads a duration the way time.ParseDuration does, plus a
+// whole number of days such as 1d.
+func parseInterval(v string) (time.Duration, error) {
+	if days := strings.TrimSuffix(v, "d"); days != v {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(v)
+}
+
+// periodBounds returns where the period of the given kind containing now
+// starts, along with the start of the period before it. Periods split in the
+// timezone of now, weeks start on Monday.
+func periodBounds(now time.Time, period string) (start time.Time, previous time.Time, err error) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch period {
+	case "day":
+		return day, day.AddDate(0, 0, -1), nil
+	case "week":
+		start = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, -7), nil
+	case "month":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, -1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, errors.New("period must be one of day, week or month")
+	}
+}
+
+// trend compares the count of the current period so far with the whole
+// previous one.
+type trend struct {
+	Period   string `json:"period"`
+	Current  int    `json:"current"`
+	Previous int    `json:"previous"`
+	// Change is the percent change from Previous to Current, nil when there
+	// is nothing to compare against.
+	Change *float64 `json:"change"`
+}
+
+func (d *Deps) periodTrend(ctx context.Context, name string, period string) (trend, error) {
+	now := d.now().In(d.location())
+
+	start, previous, err := periodBounds(now, period)
+	if err != nil {
+		return trend{}, err
+	}
+
+	t := trend{Period: period}
+	err = d.readDB().QueryRowContext(
+		ctx,
+		`SELECT
+			COALESCE(SUM(CASE WHEN julianday(created_at) >= julianday(?) THEN count END), 0),
+			COALESCE(SUM(CASE WHEN julianday(created_at) < julianday(?) THEN count END), 0)
+		FROM counter
+		WHERE name = ? AND deleted_at IS NULL AND adjustment = 0 AND julianday(created_at) >= julianday(?) AND julianday(created_at) <= julianday(?)`,
+		start,
+		start,
+		name,
+		previous,
+		now,
+	).Scan(&t.Current, &t.Previous)
+	if err != nil {
+		return trend{}, err
+	}
+
+	if t.Previous > 0 {
+		change := math.Round(float64(t.Current-t.Previous)/float64(t.Previous)*1000) / 10
+		t.Change = &change
+	}
+
+	return t, nil
+}
+
+// periodCount is the count of a single period of Compare. End is exclusive.
+type periodCount struct {
+	Count int       `json:"count"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Compare puts the current ?period= (day, week or month, default week) so far
+// next to the whole previous one. deltaPercent is the percent change between
+// them, null when the previous period had nothing to compare against.
+func (d *Deps) Compare(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "week"
+	}
+
+	start, previous, err := periodBounds(d.now().In(d.location()), period)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	t, err := d.periodTrend(ctx, name, period)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// periodBounds already rejected any other period.
+	var end time.Time
+	switch period {
+	case "day":
+		end = start.AddDate(0, 0, 1)
+	case "week":
+		end = start.AddDate(0, 0, 7)
+	case "month":
+		end = start.AddDate(0, 1, 0)
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"period":       period,
+		"current":      periodCount{Count: t.Current, Start: start, End: end},
+		"previous":     periodCount{Count: t.Previous, Start: previous, End: start},
+		"deltaPercent": t.Change,
+	})
+}
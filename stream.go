@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// streamRetry is how long an EventSource waits before reconnecting after
+	// the stream dropped, in milliseconds.
+	streamRetry = 3000
+	// streamKeepAlive is how often an idle stream sends a comment, so proxies
+	// don't close it for inactivity.
+	streamKeepAlive = time.Second * 15
+)
+
+// Stream pushes the count of the counter picked by ?name= as server-sent
+// events, one "count" event with the body of /api/list whenever it changes.
+// Each event carries the id of the update that caused it. A client that
+// reconnects with a Last-Event-ID other than the latest id is sent the current
+// count right away, so it does not miss what changed while it was gone, and
+// so is a new client without one. On shutdown the stream ends with a
+// "shutdown" event rather than a bare close.
+func (d *Deps) Stream(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	changed, id := d.updates.waitID()
+
+	counts, lastDate, err := d.streamCount(r.Context(), name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var frame bytes.Buffer
+	frame.WriteString("retry: " + strconv.Itoa(streamRetry) + "\n\n")
+
+	if r.Header.Get("Last-Event-ID") != strconv.FormatInt(id, 10) {
+		if err := d.writeCountEvent(&frame, r, id, counts, lastDate); err != nil {
+			return
+		}
+	}
+
+	if _, err := w.Write(frame.Bytes()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	shutdown := d.updates.closed()
+
+	for {
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		case <-shutdown:
+			// A final event tells the client the close is deliberate. It
+			// reconnects after streamRetry, ideally to an instance that is
+			// not going away.
+			if _, err := w.Write([]byte("event: shutdown\ndata: {}\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		changed, id = d.updates.waitID()
+
+		newCounts, newLastDate, err := d.streamCount(r.Context(), name)
+		if err != nil {
+			return
+		}
+
+		// Updates of other counters wake this stream as well.
+		if newCounts == counts && newLastDate.Equal(lastDate) {
+			continue
+		}
+		counts, lastDate = newCounts, newLastDate
+
+		frame.Reset()
+		if err := d.writeCountEvent(&frame, r, id, counts, lastDate); err != nil {
+			return
+		}
+
+		if _, err := w.Write(frame.Bytes()); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (d *Deps) streamCount(ctx context.Context, name string) (int, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*15)
+	defer cancel()
+
+	return d.latestAggregate(ctx, name)
+}
+
+// writeCountEvent writes a single count event to frame. Every line of the
+// JSON body gets its own data field, as pretty-printing spreads it over many.
+func (d *Deps) writeCountEvent(frame *bytes.Buffer, r *http.Request, id int64, counts int, lastDate time.Time) error {
+	// lastDate is null for a counter that was never aggregated.
+	var last interface{}
+	if !lastDate.IsZero() {
+		last = lastDate.Format(time.RFC3339)
+	}
+
+	body, err := d.marshalJSON(r, map[string]interface{}{
+		"counter":  counts,
+		"lastDate": last,
+	})
+	if err != nil {
+		return err
+	}
+
+	frame.WriteString("id: " + strconv.FormatInt(id, 10) + "\n")
+	frame.WriteString("event: count\n")
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		frame.WriteString("data: ")
+		frame.Write(line)
+		frame.WriteString("\n")
+	}
+	frame.WriteString("\n")
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sseEvent is an event read back from Stream.
+type sseEvent struct {
+	id   string
+	data string
+}
+
+// readEvent returns the next event of the stream that has an id, skipping
+// the retry hint.
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data += strings.TrimPrefix(line, "data: ")
+		case line == "" && event.id != "":
+			return event
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	server := httptest.NewServer(deps.Handler(deps.Routes()))
+	defer server.Close()
+
+	connect := func(lastEventID string) (*bufio.Reader, func()) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/stream", nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connecting: %v", err)
+		}
+
+		if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %q", got)
+		}
+
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+
+	reader, disconnect := connect("")
+	first := readEvent(t, reader)
+	if first.data != `{"counter":0,"lastDate":null}` {
+		t.Errorf("expected the current count right away, got %s", first.data)
+	}
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	second := readEvent(t, reader)
+	if !strings.HasPrefix(second.data, `{"counter":1,`) {
+		t.Errorf("expected the new count, got %s", second.data)
+	}
+
+	firstID, _ := strconv.ParseInt(first.id, 10, 64)
+	secondID, _ := strconv.ParseInt(second.id, 10, 64)
+	if secondID <= firstID {
+		t.Errorf("expected ids to increase, got %s then %s", first.id, second.id)
+	}
+	disconnect()
+
+	// A client that is up to date is not sent the same count again.
+	reader, disconnect = connect(second.id)
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	if event := readEvent(t, reader); !strings.HasPrefix(event.data, `{"counter":2,`) {
+		t.Errorf("expected the next change only, got %s", event.data)
+	}
+	disconnect()
+
+	// One that missed an update gets the current count replayed.
+	reader, disconnect = connect(second.id)
+	defer disconnect()
+
+	if event := readEvent(t, reader); !strings.HasPrefix(event.data, `{"counter":2,`) {
+		t.Errorf("expected the current count to be replayed, got %s", event.data)
+	}
+}
+
+func TestStreamShutdown(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	server := httptest.NewServer(deps.Handler(deps.Routes()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/stream")
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent(t, reader)
+
+	deps.updates.close()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected a shutdown event before the stream closed: %v", err)
+		}
+
+		if line == "event: shutdown\n" {
+			break
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+// subjects returns the counter names configured for the page, falling back
+// to the default counter.
+func (d *Deps) subjects() []string {
+	if len(d.Subjects) == 0 {
+		return []string{defaultCounterName}
+	}
+
+	return d.Subjects
+}
+
+// ListSubjects returns the counter names the page lets visitors switch
+// between, the first one is shown initially.
+func (d *Deps) ListSubjects(w http.ResponseWriter, r *http.Request) {
+	d.writeJSON(w, r, http.StatusOK, d.subjects())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSubjects(t *testing.T) {
+	deps := newTestDeps(t)
+
+	rec := httptest.NewRecorder()
+	deps.ListSubjects(rec, httptest.NewRequest(http.MethodGet, "/api/subjects", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `["sorry"]` {
+		t.Errorf("expected only the default counter, got %s", got)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), `<div style="text-align: center;" hidden>`) {
+		t.Error("expected the switch to be hidden with a single counter")
+	}
+
+	subjects, err := parseSubjects(" Sorry, thanks,sorry,,oops ")
+	if err != nil {
+		t.Fatalf("parsing subjects: %v", err)
+	}
+	deps.Subjects = subjects
+
+	rec = httptest.NewRecorder()
+	deps.ListSubjects(rec, httptest.NewRequest(http.MethodGet, "/api/subjects", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `["sorry","thanks","oops"]` {
+		t.Errorf("expected the configured counters in order, got %s", got)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, subject := range subjects {
+		if want := `<option value="` + subject + `">`; !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected the page to contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// IndexData is what an IndexTemplate is executed with.
+type IndexData struct {
+	Name  string
+	Count int
+	// LastDate is the time of the latest event in the configured timezone,
+	// zero when there was none.
+	LastDate time.Time
+	// AssetVersion changes with every build, the asset function appends it
+	// to a path, e.g. {{asset "/static/style.css"}}.
+	AssetVersion string
+	// ThemeCSS declares the --accent, --accent-hover, --accent-visited,
+	// --text, --background and --background-alt colour variables from
+	// THEME_MODE and THEME_ACCENT, for a <style> element.
+	ThemeCSS template.CSS
+}
+
+// loadIndexTemplate parses the HTML template at path. A broken template is
+// logged and nil is returned, so Index keeps serving the built-in page.
+func loadIndexTemplate(path string) *template.Template {
+	tmpl, err := template.New(filepath.Base(path)).
+		Funcs(template.FuncMap{"asset": assetURL}).
+		ParseFiles(path)
+	if err != nil {
+		log.Printf("Using the built-in page, parsing TEMPLATE_FILE: %v", err)
+		return nil
+	}
+
+	return tmpl
+}
+
+// renderIndexTemplate serves the page from IndexTemplate with the counter
+// picked by ?name=.
+func (d *Deps) renderIndexTemplate(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	counts, lastDate, err := d.latestAggregate(ctx, name)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := IndexData{Name: name, Count: counts, AssetVersion: assetVersion(), ThemeCSS: template.CSS(d.themeCSS())}
+	if !lastDate.IsZero() {
+		data.LastDate = lastDate.In(d.location())
+	}
+
+	// Rendering into a buffer first keeps a failing template from leaving a
+	// half written page behind a 200.
+	var page bytes.Buffer
+	if err := d.IndexTemplate.Execute(&page, data); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(page.Bytes())
+}
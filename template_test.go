@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	deps := newTestDeps(t)
+	silenceLog(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(path, []byte(`<p>{{.Name}}: {{.Count}}{{if .LastDate.IsZero}} (never){{end}}</p>`), 0o644); err != nil {
+		t.Fatalf("writing template: %v", err)
+	}
+
+	deps.IndexTemplate = loadIndexTemplate(path)
+	if deps.IndexTemplate == nil {
+		t.Fatal("expected the template to parse")
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Body.String(); got != `<p>sorry: 0 (never)</p>` {
+		t.Errorf("unexpected page %s", got)
+	}
+
+	doAdd(t, deps)
+	createAggregate(t, deps)
+
+	rec = httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Body.String(); got != `<p>sorry: 1</p>` {
+		t.Errorf("unexpected page %s", got)
+	}
+
+	assets := filepath.Join(dir, "assets.html")
+	if err := os.WriteFile(assets, []byte(`<link href="{{asset "/static/style.css"}}">{{.AssetVersion}}`), 0o644); err != nil {
+		t.Fatalf("writing template: %v", err)
+	}
+
+	deps.IndexTemplate = loadIndexTemplate(assets)
+	rec = httptest.NewRecorder()
+	deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Body.String(), `<link href="/static/style.css?v=dev">dev`; got != want {
+		t.Errorf("expected page %s, got %s", want, got)
+	}
+
+	broken := filepath.Join(dir, "broken.html")
+	if err := os.WriteFile(broken, []byte(`<p>{{.Count</p>`), 0o644); err != nil {
+		t.Fatalf("writing template: %v", err)
+	}
+
+	if loadIndexTemplate(broken) != nil {
+		t.Error("expected a broken template to fall back to the built-in page")
+	}
+
+	if loadIndexTemplate(filepath.Join(dir, "missing.html")) != nil {
+		t.Error("expected a missing template to fall back to the built-in page")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The colour schemes the page comes in, picked with THEME_MODE.
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+// themeAccentPattern matches the hex colours THEME_ACCENT accepts, e.g.
+// #1d7484 or #f80.
+var themeAccentPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// themeColors are the Sakura colours the page is styled with, see themeCSS.
+type themeColors struct {
+	Accent        string
+	AccentHover   string
+	AccentVisited string
+	Text          string
+	Background    string
+	BackgroundAlt string
+}
+
+// themes holds the stock light Sakura colours and those of its dark variant.
+var themes = map[string]themeColors{
+	ThemeLight: {
+		Accent:        "#1d7484",
+		AccentHover:   "#982c61",
+		AccentVisited: "#144f5a",
+		Text:          "#4a4a4a",
+		Background:    "#f9f9f9",
+		BackgroundAlt: "#f1f1f1",
+	},
+	ThemeDark: {
+		Accent:        "#ffffff",
+		AccentHover:   "#c9c9c9",
+		AccentVisited: "#e6e6e6",
+		Text:          "#c9c9c9",
+		Background:    "#222222",
+		BackgroundAlt: "#4a4a4a",
+	},
+}
+
+// themeColors resolves ThemeMode and ThemeAccent. An accent replaces the
+// visited link colour as well, there is no telling how to darken it.
+func (d *Deps) themeColors() themeColors {
+	colors, ok := themes[d.ThemeMode]
+	if !ok {
+		colors = themes[ThemeLight]
+	}
+
+	if d.ThemeAccent != "" {
+		colors.Accent = d.ThemeAccent
+		colors.AccentVisited = d.ThemeAccent
+	}
+
+	return colors
+}
+
+// themeCSS declares the CSS variables the built-in page is styled with. It is
+// also handed to templates, which can put it in a <style> element to follow
+// THEME_MODE and THEME_ACCENT.
+func (d *Deps) themeCSS() string {
+	colors := d.themeColors()
+
+	scheme := ThemeLight
+	if d.ThemeMode == ThemeDark {
+		scheme = ThemeDark
+	}
+
+	var b strings.Builder
+	b.WriteString(":root {\n")
+	b.WriteString("\tcolor-scheme: " + scheme + ";\n")
+	b.WriteString("\t--accent: " + colors.Accent + ";\n")
+	b.WriteString("\t--accent-hover: " + colors.AccentHover + ";\n")
+	b.WriteString("\t--accent-visited: " + colors.AccentVisited + ";\n")
+	b.WriteString("\t--text: " + colors.Text + ";\n")
+	b.WriteString("\t--background: " + colors.Background + ";\n")
+	b.WriteString("\t--background-alt: " + colors.BackgroundAlt + ";\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIndexTheme(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		accent string
+		want   []string
+	}{
+		{
+			name: "default",
+			want: []string{"color-scheme: light;", "--accent: #1d7484;", "--background: #f9f9f9;"},
+		},
+		{
+			name: "dark",
+			mode: ThemeDark,
+			want: []string{"color-scheme: dark;", "--accent: #ffffff;", "--background: #222222;"},
+		},
+		{
+			name:   "dark with an accent",
+			mode:   ThemeDark,
+			accent: "#f80",
+			want:   []string{"--accent: #f80;", "--accent-visited: #f80;", "--background: #222222;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps(t)
+			deps.ThemeMode = tt.mode
+			deps.ThemeAccent = tt.accent
+
+			rec := httptest.NewRecorder()
+			deps.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			page := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("expected the page to contain %q", want)
+				}
+			}
+		})
+	}
+}
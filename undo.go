@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	errNothingToUndo = errors.New("there is nothing to undo")
+	errNothingToRedo = errors.New("there is nothing to redo")
+)
+
+// Undo soft-deletes the latest live event of the counter picked by ?name= and
+// pushes it on the redo stack, Redo restores the event undone last. A fresh
+// Add clears the redo stack. Both answer with the new total and the time of
+// the event they affected.
+func (d *Deps) Undo(w http.ResponseWriter, r *http.Request) {
+	d.undoRedo(w, r, d.undoEvent, errNothingToUndo)
+}
+
+// Redo restores the most recently undone event, see Undo.
+func (d *Deps) Redo(w http.ResponseWriter, r *http.Request) {
+	d.undoRedo(w, r, d.redoEvent, errNothingToRedo)
+}
+
+func (d *Deps) undoRedo(
+	w http.ResponseWriter,
+	r *http.Request,
+	apply func(ctx context.Context, name string) (int, time.Time, error),
+	errNothing error,
+) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var counts int
+	var createdAt time.Time
+	err = d.retryTx(r.Context(), func() error {
+		var err error
+		counts, createdAt, err = apply(r.Context(), name)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errNothing) {
+			writeProblem(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.listCache.invalidate()
+
+	if err := d.refreshAggregate(r.Context(), name); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "the change was saved but aggregating failed: "+err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message":   "success",
+		"count":     counts,
+		"createdAt": createdAt.Format(time.RFC3339),
+	})
+}
+
+// undoEvent soft-deletes the latest live event of the named counter and
+// remembers it on the redo stack. It returns the new total and the time of
+// the undone event, or errNothingToUndo.
+func (d *Deps) undoEvent(ctx context.Context, name string) (int, time.Time, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var id int64
+	var createdAt time.Time
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT id, created_at FROM counter
+			WHERE name = ? AND deleted_at IS NULL
+			ORDER BY julianday(created_at) DESC, id DESC
+			LIMIT 1`,
+		name,
+	).Scan(&id, &createdAt)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, time.Time{}, e
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, errNothingToUndo
+		}
+
+		return 0, time.Time{}, err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE counter SET deleted_at = ? WHERE id = ?`, []interface{}{d.now(), id}},
+		{`INSERT INTO redo_stack (event_id, name) VALUES (?, ?)`, []interface{}{id, name}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			if e := tx.Rollback(); e != nil {
+				return 0, time.Time{}, e
+			}
+
+			return 0, time.Time{}, err
+		}
+	}
+
+	counts, err := liveTotal(ctx, tx, name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, time.Time{}, e
+		}
+
+		return 0, time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return counts, createdAt, nil
+}
+
+// redoEvent restores the event on top of the redo stack of the named counter.
+// It returns the new total and the time of the restored event, or
+// errNothingToRedo.
+func (d *Deps) redoEvent(ctx context.Context, name string) (int, time.Time, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) {
+			log.Println(err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, d.txOptions())
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var stackID, eventID int64
+	var createdAt time.Time
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT redo_stack.id, counter.id, counter.created_at FROM redo_stack
+			JOIN counter ON counter.id = redo_stack.event_id
+			WHERE redo_stack.name = ?
+			ORDER BY redo_stack.id DESC
+			LIMIT 1`,
+		name,
+	).Scan(&stackID, &eventID, &createdAt)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, time.Time{}, e
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, errNothingToRedo
+		}
+
+		return 0, time.Time{}, err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE counter SET deleted_at = NULL WHERE id = ?`, []interface{}{eventID}},
+		{`DELETE FROM redo_stack WHERE id = ?`, []interface{}{stackID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			if e := tx.Rollback(); e != nil {
+				return 0, time.Time{}, e
+			}
+
+			return 0, time.Time{}, err
+		}
+	}
+
+	counts, err := liveTotal(ctx, tx, name)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return 0, time.Time{}, e
+		}
+
+		return 0, time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return counts, createdAt, nil
+}
+
+// rowQuerier is met by *sql.DB, *sql.Conn and *sql.Tx alike.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// liveTotal sums the events of the named counter that are not deleted. Every
+// total handed back to a client is read through it, so they all agree.
+func liveTotal(ctx context.Context, q rowQuerier, name string) (int, error) {
+	var counts int
+	err := q.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(count), 0) FROM counter WHERE name = ? AND deleted_at IS NULL`,
+		name,
+	).Scan(&counts)
+	return counts, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	deps := newTestDeps(t)
+
+	call := func(handler http.HandlerFunc, wantStatus int) map[string]interface{} {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		if rec.Code != wantStatus {
+			t.Fatalf("expected status %d, got %d: %s", wantStatus, rec.Code, rec.Body.String())
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding response body: %v", err)
+		}
+
+		return body
+	}
+
+	call(deps.Undo, http.StatusNotFound)
+	call(deps.Redo, http.StatusNotFound)
+
+	addAt(t, deps, "2022-07-18T08:00:00Z")
+	addAt(t, deps, "2022-07-19T08:00:00Z")
+	addAt(t, deps, "2022-07-20T08:00:00Z")
+
+	if body := call(deps.Undo, http.StatusOK); body["count"] != float64(2) || body["createdAt"] != "2022-07-20T08:00:00Z" {
+		t.Errorf("expected the latest event to be undone, got %v", body)
+	}
+
+	if body := call(deps.Undo, http.StatusOK); body["count"] != float64(1) || body["createdAt"] != "2022-07-19T08:00:00Z" {
+		t.Errorf("expected the next event to be undone, got %v", body)
+	}
+
+	if body := call(deps.Redo, http.StatusOK); body["count"] != float64(2) || body["createdAt"] != "2022-07-19T08:00:00Z" {
+		t.Errorf("expected the last undone event to be restored, got %v", body)
+	}
+
+	doAdd(t, deps)
+
+	call(deps.Redo, http.StatusNotFound)
+
+	if body := call(deps.Undo, http.StatusOK); body["count"] != float64(2) {
+		t.Errorf("expected the fresh add to be undone, got %v", body)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Undo(rec, httptest.NewRequest(http.MethodGet, "/api/undo", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
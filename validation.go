@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knownSubjects are the people an increment can be attributed to. The first
+// one is the default when a request does not name anybody.
+var knownSubjects = []string{"Raymond"}
+
+// defaultCounterName is the counter requests without ?name= are about. It is
+// also what every row written before counters had names belongs to.
+const defaultCounterName = "sorry"
+
+var counterNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+const counterNameRule = "must be up to 64 letters, digits, dashes or underscores"
+
+// counterName returns the counter a request is about, taken from the name
+// query parameter. Names are case-insensitive.
+func counterName(r *http.Request) (string, error) {
+	name := strings.ToLower(r.URL.Query().Get("name"))
+	if name == "" {
+		return defaultCounterName, nil
+	}
+
+	if !counterNamePattern.MatchString(name) {
+		return "", errors.New("name " + counterNameRule)
+	}
+
+	return name, nil
+}
+
+// AddRequest is the optional JSON body accepted by Add. Every field can be
+// omitted, an empty body is an increment of DefaultWeight for the default
+// subject at the current time.
+type AddRequest struct {
+	Amount *int `json:"amount"`
+	// Weight is how severe the apology was, from minWeight to maxWeight. It
+	// is stored as the count of the event, so it cannot be combined with
+	// Amount.
+	Weight  *int   `json:"weight"`
+	Reason  string `json:"reason"`
+	Subject string `json:"subject"`
+	At      string `json:"at"`
+}
+
+// addInput is the validated form of an AddRequest.
+type addInput struct {
+	Name      string
+	Amount    int
+	Reason    string
+	Subject   string
+	CreatedAt time.Time
+	// VisitorID is who the increment is attributed to, empty when nobody.
+	VisitorID string
+}
+
+const maxReasonLength = 280
+
+const (
+	minWeight = 1
+	maxWeight = 5
+)
+
+// decodeAddRequest reads and validates the Add request body. Invalid input is
+// reported as validation errors keyed by field name, the error is only set
+// when the body could not be read at all.
+func (d *Deps) decodeAddRequest(r *http.Request) (addInput, map[string]string, error) {
+	var request AddRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		if isBodyTooLarge(err) {
+			return addInput{}, nil, err
+		}
+
+		return addInput{}, decodeErrors(err), nil
+	}
+
+	input := addInput{
+		Amount:    d.defaultWeight(),
+		Reason:    strings.TrimSpace(request.Reason),
+		Subject:   knownSubjects[0],
+		CreatedAt: d.now(),
+		VisitorID: visitorID(r.Context()),
+	}
+	fieldErrors := map[string]string{}
+
+	name, err := counterName(r)
+	if err != nil {
+		fieldErrors["name"] = counterNameRule
+	}
+
+	input.Name = name
+
+	if request.Amount != nil {
+		if *request.Amount < 1 {
+			fieldErrors["amount"] = "must be positive"
+		}
+
+		input.Amount = *request.Amount
+	}
+
+	if request.Weight != nil {
+		switch {
+		case request.Amount != nil:
+			fieldErrors["weight"] = "cannot be combined with amount"
+		case *request.Weight < minWeight || *request.Weight > maxWeight:
+			fieldErrors["weight"] = "must be between " + strconv.Itoa(minWeight) + " and " + strconv.Itoa(maxWeight)
+		}
+
+		input.Amount = *request.Weight
+	}
+
+	if len(input.Reason) > maxReasonLength {
+		fieldErrors["reason"] = "must be at most " + strconv.Itoa(maxReasonLength) + " characters"
+	}
+
+	if request.Subject != "" {
+		input.Subject = ""
+		for _, subject := range knownSubjects {
+			if strings.EqualFold(subject, request.Subject) {
+				input.Subject = subject
+			}
+		}
+
+		if input.Subject == "" {
+			fieldErrors["subject"] = "must be one of " + strings.Join(knownSubjects, ", ")
+		}
+	}
+
+	if request.At != "" {
+		at, err := time.Parse(time.RFC3339, request.At)
+		switch {
+		case err != nil:
+			fieldErrors["at"] = "must be an RFC3339 timestamp"
+		case at.After(input.CreatedAt):
+			fieldErrors["at"] = "must not be in the future"
+		default:
+			input.CreatedAt = at
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return addInput{}, fieldErrors, nil
+	}
+
+	return input, nil, nil
+}
+
+// decodeErrors turns a json decoding error into field errors, pointing at the
+// offending field whenever the decoder tells us which one it is.
+func decodeErrors(err error) map[string]string {
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) && typeError.Field != "" {
+		return map[string]string{typeError.Field: "must be of type " + typeError.Type.String()}
+	}
+
+	// The json package has no dedicated error type for unknown fields.
+	if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+		if name, e := strconv.Unquote(field); e == nil {
+			return map[string]string{name: "unknown field"}
+		}
+	}
+
+	return map[string]string{"body": "must be a valid JSON object"}
+}
+
+func writeValidationErrors(w http.ResponseWriter, fieldErrors map[string]string) {
+	p := newProblem(http.StatusBadRequest, "the request has invalid fields")
+	p.Errors = fieldErrors
+	p.write(w)
+}
+
+func (d *Deps) defaultWeight() int {
+	if d.DefaultWeight == 0 {
+		return 1
+	}
+
+	return d.DefaultWeight
+}
+
+// addColumn adds a column to an existing table, unless it is already there.
+// SQLite has no ADD COLUMN IF NOT EXISTS.
+func addColumn(ctx context.Context, tx *sql.Tx, table string, column string, definition string) error {
+	exists, err := hasColumn(ctx, tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition)
+	return err
+}
+
+// addAdjustmentColumn marks the events SetCount records, which the event
+// statistics leave out. Adjustments recorded before the column existed are
+// recognised once by their reason.
+func addAdjustmentColumn(ctx context.Context, tx *sql.Tx) error {
+	exists, err := hasColumn(ctx, tx, "counter", "adjustment")
+	if err != nil || exists {
+		return err
+	}
+
+	if err := addColumn(ctx, tx, "counter", "adjustment", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE counter SET adjustment = 1 WHERE reason LIKE 'adjusted to %'`)
+	return err
+}
+
+func hasColumn(ctx context.Context, tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+
+	exists := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			if e := rows.Close(); e != nil {
+				log.Println(e)
+			}
+
+			return false, err
+		}
+
+		if name == column {
+			exists = true
+		}
+	}
+
+	if err := rows.Close(); err != nil {
+		return false, err
+	}
+
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// addCounterID gives counter an explicit id primary key. SQLite cannot add a
+// primary key to an existing table, so the table is rebuilt with the old
+// rowids carried over as ids.
+func addCounterID(ctx context.Context, tx *sql.Tx) error {
+	exists, err := hasColumn(ctx, tx, "counter", "id")
+	if err != nil || exists {
+		return err
+	}
+
+	statements := []string{
+		`CREATE TABLE counter_with_id (
+			id INTEGER PRIMARY KEY,
+			count INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			reason TEXT,
+			deleted_at DATETIME,
+			name TEXT NOT NULL DEFAULT '` + defaultCounterName + `'
+		)`,
+		`INSERT INTO counter_with_id (id, count, created_at, reason, deleted_at, name)
+			SELECT rowid, count, created_at, reason, deleted_at, name FROM counter`,
+		`DROP TABLE counter`,
+		`ALTER TABLE counter_with_id RENAME TO counter`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"runtime/debug"
+)
+
+// Build information, set at build time with:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// Version reports which build is running.
+func (d *Deps) Version(w http.ResponseWriter, r *http.Request) {
+	d.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"version":      version,
+		"commit":       commit,
+		"buildTime":    buildTime,
+		"assetVersion": assetVersion(),
+	})
+}
+
+// vcsRevision is the commit the go tool stamped into the binary, empty when
+// it was built outside a checkout or without VCS stamping.
+var vcsRevision = buildRevision()
+
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
+
+// assetVersion identifies the static assets of this build. A development
+// build has no version of its own, its commit still changes with a deploy.
+// It is "dev" only when no commit is known either, see assetVersionKnown.
+func assetVersion() string {
+	if version != "dev" {
+		return version
+	}
+
+	if commit != "unknown" {
+		return commit
+	}
+
+	if vcsRevision != "" {
+		return vcsRevision
+	}
+
+	return version
+}
+
+// assetVersionKnown reports whether assetVersion tells builds apart. Without
+// it every build shares the same asset URLs, which must not be cached for
+// long.
+func assetVersionKnown() bool {
+	return assetVersion() != "dev"
+}
+
+// assetURL appends the asset version to the path of a static asset, so
+// browsers can cache it for long and still fetch it again after a deploy.
+func assetURL(path string) string {
+	return path + "?v=" + url.QueryEscape(assetVersion())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const visitorCookieName = "raymond_visitor"
+
+type visitorKey struct{}
+
+// visitorID returns the anonymous visitor the request came from, or an empty
+// string when attribution is disabled.
+func visitorID(ctx context.Context) string {
+	id, _ := ctx.Value(visitorKey{}).(string)
+	return id
+}
+
+// signVisitor returns the cookie value for id, the id followed by its HMAC.
+func (d *Deps) signVisitor(id string) string {
+	mac := hmac.New(sha256.New, []byte(d.CookieSecret))
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyVisitor returns the id in a cookie value if its signature is ours.
+func (d *Deps) verifyVisitor(value string) (string, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 1 {
+		return "", false
+	}
+
+	id := value[:i]
+	if !hmac.Equal([]byte(d.signVisitor(id)), []byte(value)) {
+		return "", false
+	}
+
+	return id, true
+}
+
+// Visitors gives every client an anonymous visitor id in a cookie signed with
+// CookieSecret, so Add can attribute increments for the leaderboard. Cookies
+// with a bad signature are replaced by a new id. Without a secret nothing is
+// attributed.
+func (d *Deps) Visitors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.CookieSecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var id string
+		if cookie, err := r.Cookie(visitorCookieName); err == nil {
+			id, _ = d.verifyVisitor(cookie.Value)
+		}
+
+		if id == "" {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				// Attribution is a nicety, the request goes on without it.
+				log.Printf("Generating a visitor id: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     visitorCookieName,
+				Value:    d.signVisitor(id),
+				Path:     "/",
+				MaxAge:   int((time.Hour * 24 * 365).Seconds()),
+				Secure:   r.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), visitorKey{}, id)))
+	})
+}
+
+type leaderboardEntry struct {
+	Visitor string `json:"visitor"`
+	Count   int    `json:"count"`
+}
+
+// Leaderboard lists the visitors who added the most to the counter picked by
+// ?name=, up to ?limit= of them.
+func (d *Deps) Leaderboard(w http.ResponseWriter, r *http.Request) {
+	name, err := counterName(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 100 {
+			writeProblem(w, http.StatusBadRequest, "limit must be a number between 1 and 100")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*15)
+	defer cancel()
+
+	rows, err := d.readDB().QueryContext(
+		ctx,
+		`SELECT visitor_id, SUM(count) AS total FROM counter
+			WHERE name = ? AND deleted_at IS NULL AND visitor_id IS NOT NULL
+			GROUP BY visitor_id ORDER BY total DESC, visitor_id ASC LIMIT ?`,
+		name,
+		limit,
+	)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	entries := []leaderboardEntry{}
+	for rows.Next() {
+		var entry leaderboardEntry
+		if err := rows.Scan(&entry.Visitor, &entry.Count); err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.writeJSON(w, r, http.StatusOK, entries)
+}
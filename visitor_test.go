@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVisitors(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.CookieSecret = "secret"
+	handler := deps.Visitors(http.HandlerFunc(deps.Add))
+
+	add := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/add", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("add: expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		return rec
+	}
+
+	cookies := add(nil).Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != visitorCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("expected an HttpOnly visitor cookie, got %v", cookies)
+	}
+	first := cookies[0]
+
+	if got := add(first).Result().Cookies(); len(got) != 0 {
+		t.Errorf("expected a valid cookie to be kept, got %v", got)
+	}
+	add(first)
+
+	forged := &http.Cookie{Name: visitorCookieName, Value: "0123456789abcdef.forged"}
+	cookies = add(forged).Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == forged.Value {
+		t.Errorf("expected a forged cookie to be replaced, got %v", cookies)
+	}
+
+	rec := httptest.NewRecorder()
+	deps.Leaderboard(rec, httptest.NewRequest(http.MethodGet, "/api/leaderboard", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	id := strings.SplitN(first.Value, ".", 2)[0]
+	if got := rec.Body.String(); !strings.HasPrefix(got, `[{"visitor":"`+id+`","count":3},{"visitor":"`) || strings.Count(got, "visitor") != 2 {
+		t.Errorf("expected the first visitor on top with 3, got %s", got)
+	}
+}
+
+func TestVisitorsWithoutSecret(t *testing.T) {
+	deps := newTestDeps(t)
+	handler := deps.Visitors(http.HandlerFunc(deps.Add))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+	if got := rec.Result().Cookies(); len(got) != 0 {
+		t.Errorf("expected no cookie without a secret, got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	deps.Leaderboard(rec, httptest.NewRequest(http.MethodGet, "/api/leaderboard", nil))
+	if got := rec.Body.String(); got != `[]` {
+		t.Errorf("expected an empty leaderboard, got %s", got)
+	}
+}
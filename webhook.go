@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type webhookPayload struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Count   int    `json:"count"`
+	At      string `json:"at"`
+}
+
+// Limits of the webhook delivery. A burst of increments queues up to
+// webhookQueueDepth notifications for webhookWorkers to send, anything beyond
+// is dropped rather than piling up on a slow receiver.
+const (
+	webhookWorkers        = 4
+	webhookQueueDepth     = 100
+	webhookAttempts       = 3
+	webhookAttemptTimeout = time.Second * 10
+)
+
+// webhookQueue holds the notifications waiting for RunWebhooks along with the
+// outcome of those sent so far. Its zero value is ready to use.
+type webhookQueue struct {
+	once    sync.Once
+	pending chan []byte
+
+	mu    sync.Mutex
+	stats webhookStats
+}
+
+// webhookStats is what RequestStats reports under webhook.
+type webhookStats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+	Queued    int    `json:"queued"`
+}
+
+func (q *webhookQueue) channel() chan []byte {
+	q.once.Do(func() {
+		q.pending = make(chan []byte, webhookQueueDepth)
+	})
+
+	return q.pending
+}
+
+func (q *webhookQueue) record(count func(*webhookStats)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count(&q.stats)
+}
+
+func (q *webhookQueue) snapshot() webhookStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Queued = len(q.channel())
+	return stats
+}
+
+// NotifyWebhook queues a notification of the counter total for the configured
+// WEBHOOK_URL, which RunWebhooks sends. It never blocks: with a full queue the
+// notification is dropped and logged.
+func (d *Deps) NotifyWebhook(name string, subject string, counts int, at time.Time) {
+	body, err := json.Marshal(webhookPayload{
+		Name:    name,
+		Subject: subject,
+		Count:   counts,
+		At:      at.Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("webhook: %v", err)
+		return
+	}
+
+	select {
+	case d.webhooks.channel() <- body:
+	default:
+		d.webhooks.record(func(s *webhookStats) { s.Dropped++ })
+		log.Printf("webhook: queue full, dropping the notification of %s at %d", name, counts)
+	}
+}
+
+// RunWebhooks sends the queued notifications with webhookWorkers workers
+// until ctx is done. Whatever is still queued then is dropped.
+func (d *Deps) RunWebhooks(ctx context.Context) {
+	pending := d.webhooks.channel()
+
+	var workers sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case body := <-pending:
+					d.sendWebhook(ctx, body)
+				}
+			}
+		}()
+	}
+	workers.Wait()
+
+	if n := len(pending); n > 0 {
+		log.Printf("webhook: dropping %d queued notifications", n)
+	}
+}
+
+// sendWebhook makes up to webhookAttempts attempts at delivering body. The
+// receiving end is usually a chat service that hiccups every now and then,
+// the attempts are spaced with an exponential backoff, jittered so the
+// retries of a burst do not all arrive at once.
+func (d *Deps) sendWebhook(ctx context.Context, body []byte) {
+	backoff := d.WebhookBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := d.deliverWebhook(ctx, body)
+		if err == nil {
+			d.webhooks.record(func(s *webhookStats) { s.Delivered++ })
+			return
+		}
+
+		log.Printf("webhook: delivery attempt %d failed: %v", attempt, err)
+
+		if attempt >= webhookAttempts {
+			d.webhooks.record(func(s *webhookStats) { s.Failed++ })
+			return
+		}
+
+		// Somewhere between half and all of the backoff.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.webhooks.record(func(s *webhookStats) { s.Failed++ })
+			return
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+func (d *Deps) deliverWebhook(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookAttemptTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
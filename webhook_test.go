@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	var requests int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only every other request gets through.
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	deps := newTestDeps(t)
+	deps.WebhookURL = receiver.URL
+	deps.WebhookBackoff = time.Millisecond
+	silenceLog(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deps.RunWebhooks(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	doAdd(t, deps)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for deps.webhooks.snapshot().Delivered == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the webhook was never delivered, got %+v", deps.webhooks.snapshot())
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("expected a failed attempt and a retry, got %d requests", got)
+	}
+}
+
+func TestWebhookQueueDropsWhenFull(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.WebhookURL = "http://127.0.0.1:0"
+	silenceLog(t)
+
+	// Nothing sends the notifications, so the queue fills up.
+	for i := 0; i < webhookQueueDepth+3; i++ {
+		deps.NotifyWebhook(defaultCounterName, "", i, time.Now())
+	}
+
+	stats := deps.webhooks.snapshot()
+	if stats.Queued != webhookQueueDepth || stats.Dropped != 3 {
+		t.Errorf("expected %d queued and 3 dropped, got %+v", webhookQueueDepth, stats)
+	}
+}
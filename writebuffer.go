@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// writeBuffer holds the increments that could not be written while the
+// database was unavailable, oldest first. Its zero value is ready to use.
+type writeBuffer struct {
+	mu      sync.Mutex
+	pending []addInput
+}
+
+func (b *writeBuffer) push(input addInput) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, input)
+}
+
+// take empties the buffer and returns what it held.
+func (b *writeBuffer) take() []addInput {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pending := b.pending
+	b.pending = nil
+	return pending
+}
+
+// putBack returns the increments that still could not be written to the
+// front of the buffer, ahead of anything queued in the meantime.
+func (b *writeBuffer) putBack(inputs []addInput) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(append([]addInput(nil), inputs...), b.pending...)
+}
+
+func (b *writeBuffer) depth() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.pending)
+}
+
+// bufferAdd queues an increment whose write failed with err and tells the
+// client it was accepted. The new total is unknown until the increment is
+// replayed, so only the amount is reported.
+func (d *Deps) bufferAdd(w http.ResponseWriter, r *http.Request, input addInput, err error) {
+	log.Printf("Buffering an increment of %s: %v", input.Name, err)
+	d.writeBuffer.push(input)
+
+	d.writeJSON(w, r, http.StatusAccepted, AddResponse{
+		Message: "queued",
+		Added:   input.Amount,
+	})
+}
+
+// FlushWriteBuffer writes the buffered increments in the order they were
+// accepted. It stops at the first failure, keeping the rest for the next
+// attempt, and returns the number of increments written.
+func (d *Deps) FlushWriteBuffer(ctx context.Context) (int, error) {
+	pending := d.writeBuffer.take()
+
+	names := map[string]struct{}{}
+	defer func() {
+		if len(names) == 0 {
+			return
+		}
+
+		d.listCache.invalidate()
+		for name := range names {
+			if err := d.refreshAggregate(ctx, name); err != nil {
+				log.Printf("aggregating %s after flushing the write buffer: %v", name, err)
+			}
+		}
+	}()
+
+	for i, input := range pending {
+		err := d.retryTx(ctx, func() error {
+			_, err := d.insertEvent(ctx, input)
+			return err
+		})
+		if err != nil {
+			d.writeBuffer.putBack(pending[i:])
+			return i, err
+		}
+
+		names[input.Name] = struct{}{}
+	}
+
+	return len(pending), nil
+}
+
+// RunWriteBuffer calls FlushWriteBuffer on every interval until ctx is done,
+// then makes a last attempt so increments are not lost on a clean shutdown.
+func (d *Deps) RunWriteBuffer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			if _, err := d.FlushWriteBuffer(flushCtx); err != nil {
+				log.Printf("Dropping %d buffered increments: %v", d.writeBuffer.depth(), err)
+			}
+			return
+		case <-ticker.C:
+			if d.writeBuffer.depth() == 0 {
+				continue
+			}
+
+			n, err := d.FlushWriteBuffer(ctx)
+			if n > 0 {
+				log.Printf("Replayed %d buffered increments", n)
+			}
+			if err != nil {
+				log.Printf("Replaying buffered increments: %v", err)
+			}
+		}
+	}
+}
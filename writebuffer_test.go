@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	deps := newTestDeps(t)
+	deps.EnableWriteBuffer = true
+	deps.TxMaxAttempts = 1
+	silenceLog(t)
+
+	// Moving the table away makes every insert fail like an unavailable
+	// database would.
+	if _, err := deps.DB.Exec(`ALTER TABLE counter RENAME TO counter_away`); err != nil {
+		t.Fatalf("renaming table: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		deps.Add(rec, httptest.NewRequest(http.MethodPost, "/api/add", nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	deps.RequestStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/requests", nil))
+	if got := rec.Body.String(); !strings.Contains(got, `"writeBuffer":{"queued":2}`) {
+		t.Errorf("expected the queued depth in the stats, got %s", got)
+	}
+
+	if n, err := deps.FlushWriteBuffer(context.Background()); err == nil || n != 0 {
+		t.Errorf("expected the flush to fail while the table is away, got %d, %v", n, err)
+	}
+
+	if _, err := deps.DB.Exec(`ALTER TABLE counter_away RENAME TO counter`); err != nil {
+		t.Fatalf("renaming table back: %v", err)
+	}
+
+	n, err := deps.FlushWriteBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	if n != 2 || deps.writeBuffer.depth() != 0 {
+		t.Errorf("expected both increments to be replayed, got %d with %d left", n, deps.writeBuffer.depth())
+	}
+
+	var total int
+	if err := deps.DB.QueryRow(`SELECT SUM(count) FROM counter`).Scan(&total); err != nil {
+		t.Fatalf("summing counts: %v", err)
+	}
+
+	if total != 2 {
+		t.Errorf("expected a total of 2, got %d", total)
+	}
+}